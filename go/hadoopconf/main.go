@@ -3,12 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/elazarl/hadoophelpers/go/lib/hadoopconf"
 	"github.com/elazarl/hadoophelpers/go/lib/readline"
@@ -61,6 +63,11 @@ func main() {
 			args := parseCommandLine(str)
 			if args, err := parser.ParseArgs(args); err != nil {
 				fmt.Println("error:", err)
+				if _, ok := err.(*hadoopconf.ConflictError); ok {
+					// our changes are lost, but the next command works on the files as they are now
+					opt.conf, opt.env = nil, nil
+					fmt.Println("the files were read again, run the command again to change them")
+				}
 			} else if len(args) > 0 {
 				fmt.Println("excessive arguments:", args)
 			}
@@ -68,12 +75,59 @@ func main() {
 	}
 }
 
-type getOpts struct{
-	Local bool `long:"local" short:"l" description:"show properties from local files only, not from *-default.xml"`
+type getOpts struct {
+	Local    bool   `long:"local" short:"l" description:"show properties from local files only, not from *-default.xml"`
+	Resolved bool   `long:"resolved" short:"r" description:"show values with ${...} references expanded, in addition to the raw values"`
+	File     string `long:"file" description:"show properties of this file only, such as ssl-server.xml"`
 }
 
 type setOpts struct {
 	Backup bool `long:"backup" default:"true" description:"save backup of modified files in the form of oldfile.timestamp"`
+	Force  bool `long:"force" short:"f" default:"false" description:"set keys even if a default declares them final (hadoop will ignore the new value), the value does not match the type of the default, or the default of the file has no such key"`
+	// hadoop prefers the current name, but a stale deprecated alias is confusing
+	RemoveDeprecated bool   `long:"remove-deprecated" default:"false" description:"remove deprecated aliases of the keys from the site files"`
+	File             string `long:"file" description:"set the keys in this file, such as ssl-server.xml, rather than where they are, or their prefix says they belong"`
+	AllowUnknown     bool   `long:"allow-unknown" default:"false" description:"add keys which the default of the file they belong in has not, without the other checks --force skips"`
+}
+
+type migrateOpts struct {
+	Backup bool `long:"backup" default:"true" description:"save backup of modified files in the form of oldfile.timestamp"`
+	DryRun bool `long:"dry-run" short:"n" default:"false" description:"only show the keys that would be renamed"`
+}
+
+type validateOpts struct{}
+
+type lintOpts struct {
+	RAM    string `long:"ram" description:"memory of the cluster's machines, such as 64g, to check heaps and containers against"`
+	FailOn string `long:"fail-on" default:"warning" description:"exit with an error if there are findings of this severity or above (info, warning, error or none)"`
+}
+
+type diffOpts struct {
+	Confs          []string `short:"c" long:"conf" description:"configuration dirs to compare, give it twice, or once to compare with the global --conf"`
+	Jars           []string `short:"j" long:"jars" description:"where hadoop's jars are for each --conf, = conf dir if not given"`
+	IgnoreDefaults bool     `long:"ignore-defaults" default:"false" description:"hide keys which differ only because the defaults do"`
+}
+
+type diffDefaultsOpts struct {
+	Only string `long:"only" description:"show only redundant, override or unknown keys"`
+}
+
+type historyOpts struct {
+	Brief bool `long:"brief" short:"b" default:"false" description:"only list the backed up files, without their differences from the current ones"`
+}
+
+type rollbackOpts struct {
+	Backup bool `long:"backup" default:"true" description:"save backup of the files being replaced, so the rollback can be rolled back"`
+	DryRun bool `long:"dry-run" short:"n" default:"false" description:"only show the files that would be restored"`
+}
+
+type pruneOpts struct {
+	Keep      int    `long:"keep" default:"-1" description:"keep only the newest N backups of each save"`
+	OlderThan string `long:"older-than" description:"remove backups older than this, such as 30d or 12h"`
+}
+
+type unsetOpts struct {
+	Backup bool `long:"backup" default:"true" description:"save backup of modified files in the form of oldfile.timestamp"`
 }
 
 type envAddOpts struct {
@@ -85,6 +139,19 @@ type envDelOpts struct {
 	Backup bool `long:"backup" default:"true" description:"save backup of modified files in the form of oldfile.timestamp"`
 }
 
+type envUnsetOpts struct {
+	Backup bool `long:"backup" default:"true" description:"save backup of modified files in the form of oldfile.timestamp"`
+}
+
+type jvmOpts struct {
+	Remove bool `short:"r" long:"remove" default:"false" description:"remove the options, -Xmx or -Dname are enough, rather than set them"`
+	Backup bool `long:"backup" default:"true" description:"save backup of modified files in the form of oldfile.timestamp"`
+}
+
+type effectiveOpts struct {
+	CleanEnv bool `long:"clean-env" default:"false" description:"source the env files in an empty environment, rather than the current one"`
+}
+
 type envSetOpts struct {
 	Backup bool `long:"backup" default:"true" description:"save backup of modified files in the form of oldfile.timestamp"`
 }
@@ -105,6 +172,15 @@ func (o getOpts) Execute(args []string) error {
 	}
 	t := table.New(4)
 	c := opt.getConf()
+	// references are to keys of any of hadoop's files, or to those of the file of its own
+	expander := hadoopconf.NewExpander(c)
+	if o.File != "" {
+		var err error
+		if c, err = c.Only(o.File); err != nil {
+			return err
+		}
+		expander = hadoopconf.NewExpander(opt.getConf().Lookup(c.Sites[0].ConfWithDefault))
+	}
 	keys := []string{}
 	for _, key := range c.Keys() {
 		for _, arg := range args {
@@ -114,6 +190,14 @@ func (o getOpts) Execute(args []string) error {
 			}
 		}
 	}
+	// keys of files hadoop reads on their own, such as ssl-server.xml, are only shown with --file
+	for _, arg := range args {
+		if _, src := c.SourceGet(arg); src == hadoopconf.NoSource {
+			if sites := c.SitesOf(arg); len(sites) > 0 {
+				t.Add("", arg, "!", "not read with hadoop's files, in "+siteNames(sites)+", use --file to choose")
+			}
+		}
+	}
 	if opt.UseColors() {
 		t.CellConf[0].PadLeft = []byte(sgr.FgGrey)
 		t.CellConf[1].PadLeft = []byte(sgr.FgCyan)
@@ -121,14 +205,44 @@ func (o getOpts) Execute(args []string) error {
 		t.CellConf[3].PadLeft = []byte(sgr.ResetForegroundColor + sgr.Bold)
 		t.CellConf[3].PadRight = []byte(sgr.Reset)
 	}
+	records := []record{}
 	for _, arg := range keys {
-		v, src := c.SourceGet(arg)
+		v, src, alias := c.AliasSourceGet(arg)
 		if v == "" && src == hadoopconf.NoSource {
 			t.Add("", arg, "", "no property")
 		} else if !(o.Local && strings.Contains(filepath.Base(src.Source), "default")) {
-			t.Add(filepath.Base(src.Source), arg, "=", v)
+			r := record{src.Source, arg, v, src.SourceType.String(), src.SourceType != hadoopconf.LocalFile}
+			var resolved string
+			var err error
+			if o.Resolved {
+				if resolved, err = expander.Expand(v); err == nil {
+					r.Value = resolved
+				}
+			}
+			records = append(records, r)
+			if alias != "" {
+				t.Add(filepath.Base(src.Source)+" ["+alias+"]", arg, "=", v)
+			} else {
+				t.Add(filepath.Base(src.Source), arg, "=", v)
+			}
+			if current := hadoopconf.CurrentKey(arg); current != arg {
+				t.Add("", "", "!", "deprecated, use "+current)
+			}
+			if sites := c.SitesOf(arg); len(sites) > 1 {
+				t.Add("", "", "!", "also in "+siteNames(sites[1:])+", use --file to choose")
+			}
+			if o.Resolved {
+				if err != nil {
+					t.Add("", "", "!", err.Error())
+				} else if resolved != v {
+					t.Add("", "", "=>", resolved)
+				}
+			}
 		}
 	}
+	if opt.Output != "" {
+		return writeRecords(os.Stdout, opt.Output, records)
+	}
 	fmt.Print(t.String())
 	return nil
 }
@@ -157,26 +271,501 @@ func (o setOpts) Execute(args []string) error {
 	if len(args) == 0 {
 		return errors.New("get must have nonzero number arguments")
 	}
+	c := opt.getConf()
+	if o.File != "" {
+		var err error
+		if c, err = c.Only(o.File); err != nil {
+			return err
+		}
+	}
 	keys := []string{}
 	vals := []string{}
+	sites := []*hadoopconf.Site{}
 	for _, arg := range args {
 		parts := strings.SplitN(arg, "=", 2)
 		if len(parts) != 2 {
 			return errors.New("set accepts arguments of the form x=y, no '=' in " + arg)
 		}
+		if current := hadoopconf.CurrentKey(parts[0]); current != parts[0] {
+			fmt.Println("note:", parts[0], "is deprecated, setting", current, "instead")
+			parts[0] = current
+		}
 		keys = append(keys, parts[0])
 		vals = append(vals, parts[1])
-		if _, exists := opt.getConf().SourceGet(parts[0]); exists == hadoopconf.NoSource {
-			return errors.New("cannot find key " +parts[0]+"in hadoop's defaults")
+		site, unknown, err := c.SiteFor(parts[0])
+		if o.File != "" {
+			site, unknown, err = c.Sites[0], false, nil
+		}
+		if err != nil {
+			return err
+		}
+		if unknown {
+			if !o.AllowUnknown && !o.Force {
+				return errors.New("cannot find key " + parts[0] + " in " + site.DefaultName() + " (use --force or --allow-unknown to add it to " + site.Name + " anyway, or --file to choose the file)")
+			}
+			fmt.Println("warning:", parts[0], "is not in", site.DefaultName()+", hadoop may ignore it")
+		}
+		if _, src := site.SourceGet(parts[0]); src == hadoopconf.NoSource {
+			fmt.Println("note: adding", parts[0], "to", site.Name)
+		}
+		sites = append(sites, site)
+		if in := c.SitesOf(parts[0]); len(in) > 1 {
+			fmt.Println("note:", parts[0], "is in", siteNames(in)+", setting it in", site.Name+", use --file to choose")
+		}
+		if src, final := c.FinalSource(parts[0]); final && src.SourceType != hadoopconf.LocalFile {
+			if !o.Force {
+				return errors.New("cannot override " + parts[0] + ", it is declared final in " + src.Source + " (use --force to write it anyway)")
+			}
+			fmt.Println("warning:", parts[0], "is declared final in", src.Source+", hadoop will ignore the new value")
+		}
+		if err := c.CheckValue(parts[0], parts[1]); err != nil {
+			if !o.Force {
+				return errors.New(err.Error() + " (use --force to write it anyway)")
+			}
+			fmt.Println("warning:", err)
 		}
 	}
 	for i := 0; i<len(keys); i++ {
-		opt.getConf().SetIfExist(keys[i], vals[i])
+		sites[i].Set(keys[i], vals[i])
+		for _, alias := range hadoopconf.DeprecatedAliases(keys[i]) {
+			for _, site := range c.Confs() {
+				if _, src := site.Conf.SourceGet(alias); src == hadoopconf.NoSource {
+					continue
+				} else if o.RemoveDeprecated {
+					site.Delete(alias)
+				} else {
+					fmt.Println("warning: deprecated", alias, "is still set in", filepath.Base(src.Source)+", use --remove-deprecated to remove it")
+				}
+			}
+		}
+	}
+	return c.Save(o.Backup)
+}
+
+// siteNames are the names of sites, for messages
+func siteNames(sites []*hadoopconf.Site) string {
+	names := []string{}
+	for _, site := range sites {
+		names = append(names, site.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func (o unsetOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		options := groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		opt.completeOpts = options
+		for _, site := range opt.getConf().Confs() {
+			opt.completeOpts = append(opt.completeOpts, site.Conf.Keys()...)
+		}
+		return nil
+	}
+	if len(args) == 0 {
+		return errors.New("unset must have nonzero number arguments")
+	}
+	c := opt.getConf()
+	t := assignmentTable()
+	for _, site := range c.Confs() {
+		for _, key := range matchingKeys(site.Conf.Keys(), args) {
+			old, src := site.Conf.SourceGet(key)
+			site.Delete(key)
+			t.Add(filepath.Base(src.Source), key, "was", old)
+			if v, src := c.Lookup(site).SourceGet(key); src != hadoopconf.NoSource {
+				t.Add(filepath.Base(src.Source), "", "now", v)
+			} else {
+				t.Add("", "", "now", "no property")
+			}
+		}
+	}
+	if len(t.Data) == 0 {
+		return errors.New("no property matching " + strings.Join(args, " ") + " in site files")
+	}
+	if err := c.Save(o.Backup); err != nil {
+		return err
+	}
+	fmt.Print(t.String())
+	return nil
+}
+
+func (o validateOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		options := groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		opt.completeOpts = append(options, opt.getConf().Keys()...)
+		return nil
+	}
+	if len(args) == 0 {
+		args = []string{"*"}
+	}
+	t := assignmentTable()
+	for _, invalid := range opt.getConf().Validate() {
+		if len(matchingKeys([]string{invalid.Key}, args)) == 0 {
+			continue
+		}
+		t.Add(filepath.Base(invalid.Source.Source), invalid.Key, "=", invalid.Value)
+		t.Add("", "", "!", "expected "+invalid.Expected.String())
+	}
+	if len(t.Data) == 0 {
+		return nil
+	}
+	fmt.Print(t.String())
+	return errors.New(strconv.Itoa(len(t.Data)/2) + " invalid values")
+}
+
+func (o lintOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		opt.completeOpts = groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		return nil
+	}
+	ctx := &hadoopconf.LintContext{Conf: opt.getConf()}
+	if o.RAM != "" {
+		ram, err := hadoopconf.ParseBytes(o.RAM)
+		if err != nil {
+			return errors.New("invalid --ram " + o.RAM + ": " + err.Error())
+		}
+		ctx.MachineMemory = ram
+	}
+	failOn, ok := hadoopconf.ParseSeverity(o.FailOn)
+	if !ok && o.FailOn != "none" {
+		return errors.New("invalid --fail-on " + o.FailOn + ", expected info, warning, error or none")
+	}
+	opt.setConfPath()
+	// env files are optional for lint, rules which need them are skipped
+	ctx.Env, _ = hadoopconf.NewEnv(opt.ConfPath)
+	t := table.New(3)
+	if opt.UseColors() {
+		t.CellConf[1].PadLeft = []byte(sgr.FgGrey)
+		t.CellConf[2].PadLeft = []byte(sgr.ResetForegroundColor + sgr.Bold)
+		t.CellConf[2].PadRight = []byte(sgr.Reset)
+	}
+	failed := 0
+	for _, f := range hadoopconf.Lint(ctx, hadoopconf.LintRules) {
+		severity := f.Severity.String()
+		if opt.UseColors() {
+			severity = map[hadoopconf.Severity]string{
+				hadoopconf.Error:   sgr.FgRed,
+				hadoopconf.Warning: sgr.FgYellow,
+				hadoopconf.Info:    sgr.FgGrey,
+			}[f.Severity] + severity
+		}
+		t.Add(severity, f.Rule, f.Message)
+		if ok && f.Severity >= failOn {
+			failed++
+		}
+	}
+	if len(t.Data) > 0 {
+		fmt.Print(t.String())
+	}
+	if failed > 0 {
+		return errors.New(strconv.Itoa(failed) + " findings of severity " + o.FailOn + " or above")
+	}
+	return nil
+}
+
+func (o diffOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		opt.completeOpts = groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		return nil
+	}
+	confs := append(o.Confs, args...)
+	if len(confs) == 1 {
+		opt.setConfPath()
+		confs = []string{opt.ConfPath, confs[0]}
+	}
+	if len(confs) != 2 {
+		return errors.New("diff compares two configurations, give two -c/--conf")
+	}
+	a, envA, err := loadConf(confs[0], jarsPath(o.Jars, 0))
+	if err != nil {
+		return err
+	}
+	b, envB, err := loadConf(confs[1], jarsPath(o.Jars, 1))
+	if err != nil {
+		return err
+	}
+	t := table.New(4)
+	if opt.UseColors() {
+		t.CellConf[1].PadLeft = []byte(sgr.FgCyan)
+		t.CellConf[2].PadLeft = []byte(sgr.FgGrey)
+		t.CellConf[3].PadLeft = []byte(sgr.ResetForegroundColor + sgr.Bold)
+		t.CellConf[3].PadRight = []byte(sgr.Reset)
+	}
+	colors := map[hadoopconf.DiffKind]string{
+		hadoopconf.Added:   sgr.FgGreen,
+		hadoopconf.Removed: sgr.FgRed,
+		hadoopconf.Changed: sgr.FgYellow,
+	}
+	for _, d := range append(hadoopconf.Diff(a, b), hadoopconf.DiffEnv(envA, envB)...) {
+		if o.IgnoreDefaults && d.DefaultsOnly() {
+			continue
+		}
+		kind := d.Kind.String()
+		if opt.UseColors() {
+			kind = colors[d.Kind] + kind
+		}
+		key := d.Key
+		if d.Kind != hadoopconf.Added {
+			t.Add(kind, key, filepath.Base(d.SrcA.Source), d.A)
+			kind, key = "", ""
+		}
+		if d.Kind != hadoopconf.Removed {
+			t.Add(kind, key, filepath.Base(d.SrcB.Source), d.B)
+		}
+	}
+	if len(t.Data) > 0 {
+		fmt.Print(t.String())
+	}
+	return nil
+}
+
+func (o diffDefaultsOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		options := groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		opt.completeOpts = options
+		for _, site := range opt.getConf().Confs() {
+			opt.completeOpts = append(opt.completeOpts, site.Conf.Keys()...)
+		}
+		return nil
+	}
+	if o.Only != "" && o.Only != "redundant" && o.Only != "override" && o.Only != "unknown" {
+		return errors.New("invalid --only " + o.Only + ", expected redundant, override or unknown")
+	}
+	if len(args) == 0 {
+		args = []string{"*"}
+	}
+	t := table.New(5)
+	if opt.UseColors() {
+		t.CellConf[1].PadLeft = []byte(sgr.FgGrey)
+		t.CellConf[2].PadLeft = []byte(sgr.FgCyan)
+		t.CellConf[3].PadLeft = []byte(sgr.FgGrey)
+		t.CellConf[4].PadLeft = []byte(sgr.ResetForegroundColor + sgr.Bold)
+		t.CellConf[4].PadRight = []byte(sgr.Reset)
+	}
+	colors := map[hadoopconf.OverrideKind]string{
+		hadoopconf.Redundant:  sgr.FgYellow,
+		hadoopconf.Overridden: sgr.FgGreen,
+		hadoopconf.Unknown:    sgr.FgRed,
+	}
+	for _, override := range opt.getConf().Overrides() {
+		if o.Only != "" && override.Kind.String() != o.Only || len(matchingKeys([]string{override.Key}, args)) == 0 {
+			continue
+		}
+		kind := override.Kind.String()
+		if opt.UseColors() {
+			kind = colors[override.Kind] + kind
+		}
+		t.Add(kind, filepath.Base(override.Source.Source), override.Key, "=", override.Value)
+		if override.Kind == hadoopconf.Overridden {
+			t.Add("", filepath.Base(override.DefaultSource.Source), "", "=", override.Default)
+		}
+	}
+	if len(t.Data) > 0 {
+		fmt.Print(t.String())
+	}
+	return nil
+}
+
+// backedUpFiles returns the files whose backups history, rollback and prune work on
+func backedUpFiles() []string {
+	files := opt.getConf().Files()
+	opt.setConfPath()
+	if env, err := hadoopconf.NewEnv(opt.ConfPath); err == nil {
+		files = append(files, env.Files()...)
+	}
+	return files
+}
+
+func snapshotIDs() []string {
+	ids := []string{}
+	if history, err := hadoopconf.History(backedUpFiles()); err == nil {
+		for _, s := range history {
+			ids = append(ids, s.ID())
+		}
+	}
+	return ids
+}
+
+func (o historyOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		options := groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		opt.completeOpts = append(options, snapshotIDs()...)
+		return nil
+	}
+	history, err := hadoopconf.History(backedUpFiles())
+	if err != nil {
+		return err
 	}
-	opt.getConf().Save(o.Backup)
+	for _, s := range history {
+		if len(args) > 0 && !strings.HasPrefix(s.ID(), args[0]) {
+			continue
+		}
+		if opt.UseColors() {
+			fmt.Println(sgr.Bold + s.ID() + sgr.Reset)
+		} else {
+			fmt.Println(s.ID())
+		}
+		for _, b := range s.Backups {
+			fmt.Println("  " + b.Path)
+			if o.Brief {
+				continue
+			}
+			backup, err := ioutil.ReadFile(b.Path)
+			if err != nil {
+				return err
+			}
+			// a missing file is as good as an empty one for the diff
+			current, _ := ioutil.ReadFile(b.Of)
+			for _, line := range hadoopconf.DiffLines(string(backup), string(current)) {
+				if opt.UseColors() && line[0] == '-' {
+					line = sgr.FgRed + line + sgr.Reset
+				} else if opt.UseColors() {
+					line = sgr.FgGreen + line + sgr.Reset
+				}
+				fmt.Println("    " + line)
+			}
+		}
+	}
+	return nil
+}
+
+func (o rollbackOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		options := groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		opt.completeOpts = append(options, snapshotIDs()...)
+		return nil
+	}
+	files := backedUpFiles()
+	history, err := hadoopconf.History(files)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		return errors.New("no backups to roll back to")
+	}
+	// roll back the latest change by default
+	snapshot := history[0]
+	if len(args) > 0 {
+		if snapshot, err = hadoopconf.FindSnapshot(history, args[0]); err != nil {
+			return err
+		}
+	}
+	plan, err := hadoopconf.RollbackPlan(files, snapshot.Time)
+	if err != nil {
+		return err
+	}
+	t := table.New(3)
+	for _, b := range plan {
+		t.Add(filepath.Base(b.Of), "<-", b.Path)
+	}
+	fmt.Print(t.String())
+	if o.DryRun {
+		return nil
+	}
+	if err := hadoopconf.Restore(plan, o.Backup); err != nil {
+		return err
+	}
+	// the files changed under our feet, read them again when needed
+	opt.conf, opt.env = nil, nil
 	return nil
 }
 
+func (o pruneOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		opt.completeOpts = groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		return nil
+	}
+	var maxAge time.Duration
+	if o.OlderThan != "" {
+		var err error
+		if maxAge, err = hadoopconf.ParseDuration(o.OlderThan, 24*time.Hour); err != nil || maxAge <= 0 {
+			return errors.New("invalid --older-than " + o.OlderThan + ", expected a duration such as 30d")
+		}
+	}
+	if o.Keep < 0 && maxAge == 0 {
+		return errors.New("prune needs --keep or --older-than")
+	}
+	removed, err := hadoopconf.Prune(backedUpFiles(), o.Keep, maxAge, time.Now())
+	for _, b := range removed {
+		fmt.Println("removed", b.Path)
+	}
+	return err
+}
+
+func jarsPath(jars []string, i int) string {
+	if i < len(jars) {
+		return jars[i]
+	}
+	return ""
+}
+
+// loadConf reads the configuration in confPath, for commands which work on several configurations.
+// env is nil if there are no *-env.sh files.
+func loadConf(confPath, jarsPath string) (conf *hadoopconf.HadoopConf, env hadoopconf.Envs, err error) {
+	if jarsPath == "" {
+		jarsPath = confPath
+	}
+	jars, err := hadoopconf.Jars(jarsPath)
+	if err != nil {
+		return nil, nil, errors.New("cannot find hadoop jars for " + confPath + ", specify with -j/--jars: " + err.Error())
+	}
+	if conf, err = hadoopconf.New(confPath, jars); err != nil {
+		return nil, nil, errors.New("cannot read hadoop configuration in " + confPath + ": " + err.Error())
+	}
+	env, _ = hadoopconf.NewEnv(confPath)
+	return conf, env, nil
+}
+
+func (o migrateOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		opt.completeOpts = groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		return nil
+	}
+	c := opt.getConf()
+	migrations := c.Migrations()
+	if len(migrations) == 0 {
+		fmt.Println("no deprecated keys in site files")
+		return nil
+	}
+	t := assignmentTable()
+	for _, m := range migrations {
+		t.Add(filepath.Base(m.Source.Source), m.Old, "->", m.New)
+		if m.Conflict {
+			v, _ := c.SourceGet(m.New)
+			t.Add("", "", "!", "dropping "+m.Value+", "+m.New+" is already "+v)
+		} else {
+			t.Add("", "", "=", m.Value)
+		}
+	}
+	fmt.Print(t.String())
+	if o.DryRun {
+		return nil
+	}
+	c.Migrate(migrations)
+	return c.Save(o.Backup)
+}
+
+// matchingKeys returns the keys which match any of the glob patterns
+func matchingKeys(keys []string, patterns []string) []string {
+	matches := []string{}
+	for _, key := range keys {
+		for _, pattern := range patterns {
+			if ok, _ := filepath.Match(pattern, key); ok {
+				matches = append(matches, key)
+				break
+			}
+		}
+	}
+	return matches
+}
+
 func assignmentTable() *table.Table {
 	t := table.New(4)
 	if opt.UseColors() {
@@ -215,12 +804,12 @@ func (o envSetOpts) Execute(args []string) error {
 	if len(args) == 0 {
 		return errors.New("get must have nonzero number arguments")
 	}
-	v := opt.getEnv().Get(args[0])
+	v := opt.getEnv().GetOrAdd(args[0])
 	if v == nil {
-		fmt.Println("No such variable", v)
+		return errors.New("no env file to add " + args[0] + " to")
 	}
 	t := assignmentTable()
-	t.Add(filepath.Base(v.Source), v.Name, "was", v.GetVal())
+	t.Add(filepath.Base(v.Source), v.Name, "was", envWas(v))
 	v.SetVal(strings.Join(args[1:], " "))
 	t.Add("", "", "now", v.GetVal())
 	if err := opt.getEnv().Save(o.Backup); err != nil {
@@ -242,12 +831,31 @@ func (o envAddOpts) Execute(args []string) error {
 	if len(args) == 0 {
 		return errors.New("get must have nonzero number arguments")
 	}
-	v := opt.getEnv().Get(args[0])
+	if envs := opt.getEnv(); envs.Hadoop3() {
+		name := hadoopconf.CurrentEnvVar(args[0])
+		if name != args[0] {
+			fmt.Println("note:", args[0], "is deprecated in hadoop 3, adding to", name, "instead")
+		}
+		// hadoop 3 adds options with hadoop_add_param, and the classpath with hadoop_add_classpath
+		if calls := envs.AddParams(name, args[1:], !o.Append); calls != nil {
+			t := assignmentTable()
+			for _, v := range calls {
+				t.Add(filepath.Base(v.Source), v.Name, v.Func, v.GetVal())
+			}
+			if err := envs.Save(o.Backup); err != nil {
+				return err
+			}
+			fmt.Print(t.String())
+			return nil
+		}
+		args[0] = name
+	}
+	v := opt.getEnv().GetOrAdd(args[0])
 	if v == nil {
-		fmt.Println("No such variable", v)
+		return errors.New("no env file to add " + args[0] + " to")
 	}
 	t := assignmentTable()
-	t.Add(filepath.Base(v.Source), v.Name, "was", v.GetVal())
+	t.Add(filepath.Base(v.Source), v.Name, "was", envWas(v))
 	if o.Append {
 		v.Append(strings.Join(args[1:], " "))
 	} else {
@@ -278,8 +886,8 @@ func (o envDelOpts) Execute(args []string) error {
 		return errors.New("get must have nonzero number arguments")
 	}
 	v := opt.getEnv().Get(args[0])
-	if v == nil {
-		fmt.Println("No such variable", v)
+	if v == nil || !v.IsSet() {
+		return errors.New("no variable " + args[0] + " is set")
 	}
 	t := assignmentTable()
 	t.Add(filepath.Base(v.Source), v.Name, "was", v.GetVal())
@@ -292,6 +900,173 @@ func (o envDelOpts) Execute(args []string) error {
 	return nil
 }
 
+// envWas is what a variable was before it's changed
+func envWas(v *hadoopconf.Var) string {
+	if !v.IsSet() {
+		return "not set"
+	}
+	return v.GetVal()
+}
+
+func (o envUnsetOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		options := groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		opt.completeOpts = append(options, opt.getEnv().Keys()...)
+		return nil
+	}
+	if len(args) == 0 {
+		return errors.New("envunset must have nonzero number arguments")
+	}
+	envs := opt.getEnv()
+	t := assignmentTable()
+	for _, name := range matchingKeys(envs.Keys(), args) {
+		vars, err := envs.Unset(name)
+		if err != nil {
+			// forget the variables already commented out
+			opt.env = nil
+			return err
+		}
+		for _, v := range vars {
+			t.Add(filepath.Base(v.Source), v.Name, "was", v.GetVal())
+			t.Add("", "", "now", "commented out")
+		}
+	}
+	if len(t.Data) == 0 {
+		return errors.New("no variable matching " + strings.Join(args, " ") + " is set")
+	}
+	if err := envs.Save(o.Backup); err != nil {
+		return err
+	}
+	fmt.Print(t.String())
+	return nil
+}
+
+func (o jvmOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		options := groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		if len(args) == 0 {
+			opt.completeOpts = options
+			for _, d := range hadoopconf.Daemons {
+				opt.completeOpts = append(opt.completeOpts, d.Name)
+			}
+		} else if d, ok := hadoopconf.FindDaemon(args[0]); ok {
+			if v := opt.getEnv().Get(opt.getEnv().Launch(d).Opts); v != nil {
+				for _, jo := range v.JvmOpts().Options {
+					if !jo.Ref {
+						opt.completeOpts = append(opt.completeOpts, jo.Key)
+					}
+				}
+			}
+		}
+		return nil
+	}
+	daemons := hadoopconf.Daemons
+	if len(args) > 0 {
+		d, ok := hadoopconf.FindDaemon(args[0])
+		if !ok {
+			return errors.New("no daemon " + args[0] + ", try namenode, datanode, resourcemanager or client")
+		}
+		daemons = []hadoopconf.Daemon{d}
+	}
+	if len(args) <= 1 {
+		t := assignmentTable()
+		for _, d := range daemons {
+			d = opt.getEnv().Launch(d)
+			if v := opt.getEnv().Get(d.Opts); v != nil && v.IsSet() && v.Func == "" {
+				for _, jo := range v.JvmOpts().Options {
+					t.Add(d.Name, v.Name, filepath.Base(v.Source), jo.Text)
+				}
+			}
+			for _, call := range opt.getEnv().Calls(d.Opts) {
+				t.Add(d.Name, call.Name, filepath.Base(call.Source), call.GetVal())
+			}
+		}
+		fmt.Print(t.String())
+		return nil
+	}
+	d := opt.getEnv().Launch(daemons[0])
+	if old := opt.getEnv().Get(daemons[0].Opts); d.Opts != daemons[0].Opts && old != nil && old.IsSet() {
+		fmt.Println("note:", old.Name, "is deprecated in hadoop 3, but it's set, and hadoop prefers it to", d.Opts)
+		d.Opts = old.Name
+	}
+	v := opt.getEnv().GetOrAdd(d.Opts)
+	if v == nil {
+		return errors.New("no env file to add " + d.Opts + " to")
+	}
+	if v.Func != "" {
+		return errors.New(d.Opts + " is only added to with " + v.Func + ", use envadd " + d.Opts + " to add options")
+	}
+	t := assignmentTable()
+	t.Add(filepath.Base(v.Source), v.Name, "was", envWas(v))
+	opts := v.JvmOpts()
+	if !v.IsSet() && !opt.getEnv().Hadoop3() {
+		// a new variable keeps the options of the scripts, hadoop 3's scripts don't set any
+		opts = hadoopconf.ParseJvmOpts("$" + d.Opts)
+	}
+	for _, option := range args[1:] {
+		if o.Remove {
+			if opts.Remove(option) == 0 {
+				return errors.New(d.Opts + " has no option " + option)
+			}
+		} else {
+			opts.Set(option)
+		}
+	}
+	v.SetJvmOpts(opts)
+	t.Add("", "", "now", v.GetVal())
+	if err := opt.getEnv().Save(o.Backup); err != nil {
+		return err
+	}
+	fmt.Print(t.String())
+	return nil
+}
+
+func (o effectiveOpts) Execute(args []string) error {
+	opt.executed = true
+	if opt.completeOpts != nil {
+		options := groupsOptions(opt.parser.Find(substructCommandTag(o, &opt)).Groups())
+		opt.completeOpts = options
+		for _, d := range hadoopconf.Daemons {
+			opt.completeOpts = append(opt.completeOpts, d.Name)
+		}
+		return nil
+	}
+	daemons := []hadoopconf.Daemon{}
+	for _, d := range hadoopconf.Daemons {
+		if len(args) == 0 || len(matchingKeys([]string{d.Name}, args)) > 0 {
+			daemons = append(daemons, d)
+		}
+	}
+	if len(daemons) == 0 {
+		return errors.New("no daemon matching " + strings.Join(args, " ") + ", try namenode, datanode, resourcemanager or client")
+	}
+	base := hadoopconf.OsEnvironment()
+	if o.CleanEnv {
+		base = hadoopconf.Environment{}
+	}
+	envs := opt.getEnv()
+	t := assignmentTable()
+	for _, d := range daemons {
+		e := envs.Effective(d, base)
+		d = e.Daemon
+		if len(args) == 0 && envs.Get(d.Opts) == nil {
+			// not a daemon of this hadoop version
+			continue
+		}
+		t.Add(d.Name, "JAVA_HOME", "=", e.JavaHome)
+		t.Add("", d.Java, "=", e.Env[d.Java])
+		t.Add("", d.Opts, "=", e.Env[d.Opts])
+		t.Add("", "java", "=", e.Opts.String())
+		for _, v := range e.Skipped {
+			t.Add("", v.Name, "maybe", v.GetVal()+" ("+filepath.Base(v.Source)+":"+strconv.Itoa(v.Line())+")")
+		}
+	}
+	fmt.Print(t.String())
+	return nil
+}
+
 func (o envOpts) Execute(args []string) error {
 	opt.executed = true
 	if opt.completeOpts != nil {
@@ -313,36 +1088,80 @@ func (o envOpts) Execute(args []string) error {
 			}
 		}
 	}
+	records := []record{}
+	hadoop3 := c.Hadoop3()
 	for _, arg := range keys {
 		v := c.Get(arg)
 		if v == nil {
 			t.Add("", arg, "", "no property")
-		} else {
+			continue
+		}
+		if !v.IsSet() {
+			// a commented out template, such as "# export HADOOP_HEAPSIZE=2000", is not in effect
+			t.Add(filepath.Base(v.Source), arg, "#", v.Comment)
+			continue
+		}
+		if v.Func == "" {
 			t.Add(filepath.Base(v.Source), arg, "=", v.GetVal())
+			records = append(records, record{v.Source, arg, v.GetVal(), "env", false})
 		}
+		for _, call := range c.Calls(arg) {
+			if !call.IsSet() {
+				continue
+			}
+			t.Add(filepath.Base(call.Source), arg, "+=", call.GetVal())
+			records = append(records, record{call.Source, arg, call.GetVal(), "env", false})
+		}
+		if current := hadoopconf.CurrentEnvVar(arg); hadoop3 && current != arg && v.IsSet() {
+			t.Add("", "", "!", "deprecated in hadoop 3, use "+current)
+		}
+	}
+	if opt.Output != "" {
+		return writeRecords(os.Stdout, opt.Output, records)
 	}
 	fmt.Print(t.String())
 	return nil
 }
 
 func (stat *statOpts) Execute(args []string) error {
+	opt.executed = true
 	t := table.New(2)
+	records := []record{}
+	add := func(name, path string, sourceType string, isDefault bool, color string) {
+		if opt.UseColors() {
+			t.Add(name, color+path)
+		} else {
+			t.Add(name, path)
+		}
+		records = append(records, record{path, name, path, sourceType, isDefault})
+	}
 	c := opt.getConf()
-	t.Add("core-site.xml", sgr.FgYellow+c.CoreSite.Conf.Source())
-	t.Add("hdfs-site.xml", sgr.FgYellow+c.HdfsSite.Conf.Source())
-	t.Add("mapred-site.xml", sgr.FgYellow+c.MapredSite.Conf.Source())
-	if c.YarnSite.Default != nil {
-		t.Add("yarn-site.xml", sgr.FgYellow+c.YarnSite.Conf.Source())
-	}
-	t.Add("core-default.xml", c.CoreSite.Default.Source())
-	t.Add("hdfs-default.xml", c.HdfsSite.Default.Source())
-	t.Add("mapred-default.xml", c.MapredSite.Default.Source())
-	// This is not mistake, the yarn-site.xml may not exist now, the default must exist
-	if c.YarnSite.Default != nil {
-		t.Add("yarn-default.xml", c.YarnSite.Default.Source())
+	for _, site := range c.Sites {
+		// a file which isn't there, and has no default, such as yarn-site.xml of hadoop 1, is of no interest
+		if _, err := os.Stat(site.Conf.Source()); err == nil || site.Default != nil {
+			add(site.Name, site.Conf.Source(), "file", false, sgr.FgYellow)
+		}
+	}
+	for _, site := range c.Confs() {
+		if fc, ok := site.Conf.(*hadoopconf.FileConfiguration); ok {
+			for _, fragment := range fc.Fragments() {
+				add(filepath.Base(fc.Path)+" include", fragment.Path, "file", false, sgr.FgYellow)
+			}
+		}
+	}
+	for _, site := range c.Sites {
+		if site.Default != nil {
+			add(filepath.Base(site.Default.Source()), site.Default.Source(), "jar", true, "")
+		}
 	}
 	for _, env := range opt.getEnv() {
-		t.Add(filepath.Base(env.Path), sgr.FgGreen+env.Path)
+		add(filepath.Base(env.Path), env.Path, "env", false, sgr.FgGreen)
+	}
+	if c.Layout != nil {
+		add(c.Layout.Name+" layout", c.Layout.Root, "layout", false, "")
+	}
+	if opt.Output != "" {
+		return writeRecords(os.Stdout, opt.Output, records)
 	}
 	if opt.UseColors() {
 		t.CellConf[0].PadLeft = []byte(sgr.FgGrey)
@@ -369,22 +1188,38 @@ func (helpOpts) Execute(args []string) error {
 }
 
 type gOpts struct {
-	Get      getOpts    `command:"get"`
-	Set      setOpts    `command:"set"`
-	SetEnv   envSetOpts `command:"envset"`
-	AddEnv   envAddOpts `command:"envadd"`
-	DelEnv   envDelOpts `command:"envdel"`
-	Stat     statOpts   `command:"stat"`
-	Env      envOpts    `command:"env"`
-	HelpCmd  helpOpts   `command:"help"`
-	Help     bool       `short:"h" long:"help" default:"false" description:"print help"`
-	Verbose  bool       `short:"v" long:"verbose" default:"false" description:"Show verbose debug information"`
-	Color    string     `long:"color" description:"use colors on output" default:"auto"`
-	ConfPath string     `short:"c" long:"conf" description:"Set hadoop configuration dir"`
-	JarsPath string     `short:"j" long:"jars" description:"where hadoop's jar are (also searches in DIR/share/hadoop/...), = conf dir if empty"`
-	conf     *hadoopconf.HadoopConf
-	env      hadoopconf.Envs
-	executed bool
+	Get          getOpts          `command:"get"`
+	Set          setOpts          `command:"set"`
+	Unset        unsetOpts        `command:"unset"`
+	Migrate      migrateOpts      `command:"migrate"`
+	Validate     validateOpts     `command:"validate"`
+	Lint         lintOpts         `command:"lint"`
+	Diff         diffOpts         `command:"diff"`
+	DiffDefaults diffDefaultsOpts `command:"diff-defaults"`
+	History      historyOpts      `command:"history"`
+	Rollback     rollbackOpts     `command:"rollback"`
+	Prune        pruneOpts        `command:"prune"`
+	SetEnv       envSetOpts       `command:"envset"`
+	AddEnv       envAddOpts       `command:"envadd"`
+	DelEnv       envDelOpts       `command:"envdel"`
+	UnsetEnv     envUnsetOpts     `command:"envunset"`
+	Jvm          jvmOpts          `command:"jvm"`
+	Effective    effectiveOpts    `command:"effective"`
+	Stat         statOpts         `command:"stat"`
+	Env          envOpts          `command:"env"`
+	HelpCmd      helpOpts         `command:"help"`
+	Help         bool             `short:"h" long:"help" default:"false" description:"print help"`
+	Verbose      bool             `short:"v" long:"verbose" default:"false" description:"Show verbose debug information"`
+	Color        string           `long:"color" description:"use colors on output" default:"auto"`
+	ConfPath     string           `short:"c" long:"conf" description:"Set hadoop configuration dir"`
+	Output       string           `short:"o" long:"output" description:"print get, env and stat as json, yaml, csv or properties records instead of a table"`
+	NewFileMode  string           `long:"new-file-mode" default:"0644" description:"mode of files hadoopconf creates, files it replaces keep their mode"`
+	OnConflict   string           `long:"on-conflict" default:"refuse" description:"when a file changed since hadoopconf read it: refuse to save, merge the changes, or overwrite them"`
+	NewFileOwner string           `long:"new-file-owner" description:"user[:group] of files hadoopconf creates, files it replaces keep their owner"`
+	JarsPath     string           `short:"j" long:"jars" description:"where hadoop's jar are (also searches in DIR/share/hadoop/...), = conf dir if empty"`
+	conf         *hadoopconf.HadoopConf
+	env          hadoopconf.Envs
+	executed     bool
 	// set this to []string{} if you want command line options to autocomplete instead of executing themselves
 	completeOpts        []string
 	completionCandidate string
@@ -403,12 +1238,40 @@ func (opt *gOpts) setConfPath() {
 	opt.ConfPath = p
 }
 
+// setSavePolicy applies --on-conflict, and --new-file-mode and --new-file-owner to the files hadoopconf creates
+func (opt *gOpts) setSavePolicy() {
+	switch opt.OnConflict {
+	case "refuse":
+		hadoopconf.OnConflict = hadoopconf.Refuse
+	case "merge":
+		hadoopconf.OnConflict = hadoopconf.Merge
+	case "overwrite":
+		hadoopconf.OnConflict = hadoopconf.Overwrite
+	default:
+		fmt.Println("bad --on-conflict", opt.OnConflict+", expected refuse, merge or overwrite")
+		os.Exit(1)
+	}
+	mode, err := strconv.ParseUint(opt.NewFileMode, 8, 32)
+	if err != nil || mode > 0777 {
+		fmt.Println("bad --new-file-mode", opt.NewFileMode+", expected octal such as 0644")
+		os.Exit(1)
+	}
+	hadoopconf.NewFiles = hadoopconf.FilePolicy{Mode: os.FileMode(mode), Uid: -1, Gid: -1}
+	if opt.NewFileOwner != "" {
+		if hadoopconf.NewFiles.Uid, hadoopconf.NewFiles.Gid, err = hadoopconf.ParseOwner(opt.NewFileOwner); err != nil {
+			fmt.Println("bad --new-file-owner:", err)
+			os.Exit(1)
+		}
+	}
+}
+
 func (opt *gOpts) getEnv() hadoopconf.Envs {
 	if opt.env != nil {
 		return opt.env
 	}
 	var err error
 	opt.setConfPath()
+	opt.setSavePolicy()
 	opt.env, err = hadoopconf.NewEnv(opt.ConfPath)
 	if err != nil {
 		fmt.Println(err)
@@ -432,6 +1295,7 @@ func (opt *gOpts) getConf() *hadoopconf.HadoopConf {
 	if jarsPath == "" {
 		jarsPath = p
 	}
+	opt.setSavePolicy()
 	jars, err := hadoopconf.Jars(jarsPath)
 	if err != nil {
 		fmt.Println("cannot find hadoop jars. Specify explicitly with -j/--jars")
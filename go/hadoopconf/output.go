@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// record is a row of get, env or stat in a machine readable format
+type record struct {
+	File       string `json:"file"`
+	Key        string `json:"key"`
+	Value      string `json:"value"`
+	SourceType string `json:"source_type"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+var outputFormats = map[string]func(io.Writer, []record) error{
+	"json":       writeJSON,
+	"yaml":       writeYAML,
+	"csv":        writeCSV,
+	"properties": writeProperties,
+}
+
+// writeRecords writes records in format, which is one of outputFormats
+func writeRecords(w io.Writer, format string, records []record) error {
+	write, ok := outputFormats[format]
+	if !ok {
+		return errors.New("unknown output format " + format + ", expected json, yaml, csv or properties")
+	}
+	return write(w, records)
+}
+
+func writeJSON(w io.Writer, records []record) error {
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// writeYAML writes a list of mappings. Strings are double quoted, as go quoted strings are valid YAML.
+func writeYAML(w io.Writer, records []record) error {
+	if len(records) == 0 {
+		_, err := fmt.Fprintln(w, "[]")
+		return err
+	}
+	for _, r := range records {
+		_, err := fmt.Fprintf(w, "- file: %s\n  key: %s\n  value: %s\n  source_type: %s\n  is_default: %t\n",
+			strconv.Quote(r.File), strconv.Quote(r.Key), strconv.Quote(r.Value), r.SourceType, r.IsDefault)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCSV(w io.Writer, records []record) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"file", "key", "value", "source_type", "is_default"})
+	for _, r := range records {
+		cw.Write([]string{r.File, r.Key, r.Value, r.SourceType, strconv.FormatBool(r.IsDefault)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeProperties writes key=value lines, escaped like java's Properties.store
+func writeProperties(w io.Writer, records []record) error {
+	for _, r := range records {
+		if _, err := fmt.Fprintln(w, escapeProperty(r.Key, true)+"="+escapeProperty(r.Value, false)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeProperty(s string, isKey bool) string {
+	b := []byte{}
+	for i, r := range s {
+		switch {
+		case r == ' ' && (isKey || i == 0):
+			b = append(b, `\ `...)
+		case r == '\t':
+			b = append(b, `\t`...)
+		case r == '\n':
+			b = append(b, `\n`...)
+		case r == '\r':
+			b = append(b, `\r`...)
+		case r == '\f':
+			b = append(b, `\f`...)
+		case strings.ContainsRune(`\=:#!`, r):
+			b = append(b, '\\', byte(r))
+		case r > unicode.MaxASCII || r < ' ':
+			for _, c := range utf16Units(r) {
+				b = append(b, fmt.Sprintf(`\u%04X`, c)...)
+			}
+		default:
+			b = append(b, byte(r))
+		}
+	}
+	return string(b)
+}
+
+// utf16Units returns the UTF-16 code units of r, which java escapes one by one
+func utf16Units(r rune) []rune {
+	if r < 0x10000 {
+		return []rune{r}
+	}
+	r -= 0x10000
+	return []rune{0xD800 + (r>>10)&0x3FF, 0xDC00 + r&0x3FF}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestWriteRecords(t *testing.T) {
+	Terst(t)
+	records := []record{
+		{"/etc/hadoop/core-site.xml", "fs.defaultFS", "hdfs://nn:8020", "file", false},
+		{"core-default.xml", "a key", "x=\"y\"\n", "jar", true},
+	}
+	out := func(format string) string {
+		b := new(bytes.Buffer)
+		Is(writeRecords(b, format, records), nil)
+		return b.String()
+	}
+	Is(out("csv"), `file,key,value,source_type,is_default
+/etc/hadoop/core-site.xml,fs.defaultFS,hdfs://nn:8020,file,false
+core-default.xml,a key,"x=""y""
+",jar,true
+`)
+	Is(out("properties"), `fs.defaultFS=hdfs\://nn\:8020
+a\ key=x\="y"\n
+`)
+	Is(out("yaml"), `- file: "/etc/hadoop/core-site.xml"
+  key: "fs.defaultFS"
+  value: "hdfs://nn:8020"
+  source_type: file
+  is_default: false
+- file: "core-default.xml"
+  key: "a key"
+  value: "x=\"y\"\n"
+  source_type: jar
+  is_default: true
+`)
+	Is(out("json")[:2], "[\n")
+	IsNot(writeRecords(new(bytes.Buffer), "xml", records), nil)
+	Is(escapeProperty(" é😀", false), `\ \u00E9\uD83D\uDE00`)
+}
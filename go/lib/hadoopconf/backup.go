@@ -0,0 +1,189 @@
+package hadoopconf
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupFormat is the time format of the suffix Save adds to backups of the files it replaces
+const BackupFormat = ".2006-01-02_15_04_05.000"
+
+// backups written before seconds were part of the suffix
+const legacyBackupFormat = ".2006-01-02_15_04.000"
+
+func backupSuffix(t time.Time) string {
+	return t.Format(BackupFormat)
+}
+
+// parseBackupSuffix returns the time of a backup suffix, in either format
+func parseBackupSuffix(suffix string) (time.Time, bool) {
+	for _, format := range []string{BackupFormat, legacyBackupFormat} {
+		if t, err := time.ParseInLocation(format, suffix, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Backup is a copy of a file, taken by Save before replacing it
+type Backup struct {
+	// the backup file
+	Path string
+	// the file it's a backup of
+	Of   string
+	Time time.Time
+}
+
+// Backups returns the backups of path, oldest first
+func Backups(path string) ([]Backup, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	backups := []Backup{}
+	for _, match := range matches {
+		if t, ok := parseBackupSuffix(strings.TrimPrefix(match, path)); ok {
+			backups = append(backups, Backup{match, path, t})
+		}
+	}
+	sort.Sort(byTime(backups))
+	return backups, nil
+}
+
+type byTime []Backup
+
+func (b byTime) Len() int           { return len(b) }
+func (b byTime) Less(i, j int) bool { return b[i].Time.Before(b[j].Time) }
+func (b byTime) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+
+// Snapshot is the backups a single save took, of all the files it changed
+type Snapshot struct {
+	Time    time.Time
+	Backups []Backup
+}
+
+// ID identifies the snapshot, it's the backup suffix without the leading dot
+func (s *Snapshot) ID() string {
+	return backupSuffix(s.Time)[1:]
+}
+
+// History returns the snapshots of files, newest first
+func History(files []string) ([]Snapshot, error) {
+	byStamp := map[time.Time]*Snapshot{}
+	for _, file := range files {
+		backups, err := Backups(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range backups {
+			if byStamp[b.Time] == nil {
+				byStamp[b.Time] = &Snapshot{Time: b.Time}
+			}
+			byStamp[b.Time].Backups = append(byStamp[b.Time].Backups, b)
+		}
+	}
+	snapshots := []Snapshot{}
+	for _, s := range byStamp {
+		snapshots = append(snapshots, *s)
+	}
+	sort.Sort(sort.Reverse(snapshotsByTime(snapshots)))
+	return snapshots, nil
+}
+
+type snapshotsByTime []Snapshot
+
+func (s snapshotsByTime) Len() int           { return len(s) }
+func (s snapshotsByTime) Less(i, j int) bool { return s[i].Time.Before(s[j].Time) }
+func (s snapshotsByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// FindSnapshot returns the snapshot whose ID starts with id, it's an error if there
+// are none or several
+func FindSnapshot(snapshots []Snapshot, id string) (Snapshot, error) {
+	found := []Snapshot{}
+	for _, s := range snapshots {
+		if strings.HasPrefix(s.ID(), strings.TrimPrefix(id, ".")) {
+			found = append(found, s)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return Snapshot{}, errors.New("no backup at " + id)
+	case 1:
+		return found[0], nil
+	}
+	ids := []string{}
+	for _, s := range found {
+		ids = append(ids, s.ID())
+	}
+	return Snapshot{}, errors.New(id + " is ambiguous, it could be " + strings.Join(ids, ", "))
+}
+
+// RollbackPlan returns the backups which restore files to their state right before the save at t.
+// A file which was not changed at t, but by a later save, is restored from the backup of the
+// first such save, which is its content at t.
+func RollbackPlan(files []string, t time.Time) ([]Backup, error) {
+	restore := []Backup{}
+	for _, file := range files {
+		backups, err := Backups(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range backups {
+			if !b.Time.Before(t) {
+				restore = append(restore, b)
+				break
+			}
+		}
+	}
+	return restore, nil
+}
+
+// Restore replaces the files by their backups, all or nothing. If backup is true, the current
+// files are kept as backups, so the restore can itself be rolled back.
+func Restore(backups []Backup, backup bool) error {
+	suffix := ""
+	if backup {
+		suffix = backupSuffix(time.Now())
+	}
+	tx := newTransaction(suffix)
+	for _, b := range backups {
+		content, err := ioutil.ReadFile(b.Path)
+		if err == nil {
+			err = tx.Write(b.Of, nil, content, nil)
+		}
+		if err != nil {
+			tx.Abort()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Prune removes the backups of files, except the keep newest snapshots, and those younger than maxAge.
+// keep < 0 or maxAge = 0 disable the respective limit. It returns the removed backups.
+func Prune(files []string, keep int, maxAge time.Duration, now time.Time) ([]Backup, error) {
+	snapshots, err := History(files)
+	if err != nil {
+		return nil, err
+	}
+	removed := []Backup{}
+	for i, s := range snapshots {
+		tooMany := keep >= 0 && i >= keep
+		tooOld := maxAge > 0 && now.Sub(s.Time) > maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		for _, b := range s.Backups {
+			if err := os.Remove(b.Path); err != nil {
+				return removed, err
+			}
+			removed = append(removed, b)
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,84 @@
+package hadoopconf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestBackupSuffix(t *testing.T) {
+	Terst(t)
+	stamp := time.Date(2013, 10, 1, 12, 30, 15, 250e6, time.Local)
+	Is(backupSuffix(stamp), ".2013-10-01_12_30_15.250")
+	parsed, ok := parseBackupSuffix(".2013-10-01_12_30_15.250")
+	Is(ok, true)
+	Is(parsed.Equal(stamp), true)
+	parsed, ok = parseBackupSuffix(".2013-10-01_12_30.250")
+	Is(ok, true)
+	Is(parsed.Minute(), 30)
+	_, ok = parseBackupSuffix(".orig")
+	Is(ok, false)
+}
+
+func TestHistoryRollback(t *testing.T) {
+	Terst(t)
+	// three saves: the first changed core-site.xml, the second both files, the third hdfs-site.xml
+	dir := writeFiles(t, map[string]string{
+		"core-site.xml":                         "core 2",
+		"core-site.xml.2013-10-01_12_00_00.000": "core 0",
+		"core-site.xml.2013-10-01_13_00_00.000": "core 1",
+		"hdfs-site.xml":                         "hdfs 2",
+		"hdfs-site.xml.2013-10-01_13_00_00.000": "hdfs 0",
+		"hdfs-site.xml.2013-10-01_14_00_00.000": "hdfs 1",
+		"hdfs-site.xml.orig":                    "not a backup",
+	})
+	defer os.RemoveAll(dir)
+	core, hdfs := filepath.Join(dir, "core-site.xml"), filepath.Join(dir, "hdfs-site.xml")
+	files := []string{core, hdfs}
+	history, err := History(files)
+	FailOnErr(err)
+	if Is(len(history), 3) {
+		Is(history[0].ID(), "2013-10-01_14_00_00.000")
+		Is(len(history[1].Backups), 2)
+		Is(len(history[2].Backups), 1)
+	}
+	s, err := FindSnapshot(history, "2013-10-01_13")
+	FailOnErr(err)
+	Is(s.ID(), "2013-10-01_13_00_00.000")
+	_, err = FindSnapshot(history, "2013-10-01")
+	IsNot(err, nil)
+
+	// rolling back to the second save restores both files, as they were before it
+	plan, err := RollbackPlan(files, s.Time)
+	FailOnErr(err)
+	Is(len(plan), 2)
+	FailOnErr(Restore(plan, true))
+	Is(readFile(core), "core 1")
+	Is(readFile(hdfs), "hdfs 0")
+	history, err = History(files)
+	FailOnErr(err)
+	Is(len(history), 4)
+
+	// core-site.xml was not changed by the third save, but by the rollback since,
+	// which kept its content at the third save as a backup
+	plan, err = RollbackPlan(files, time.Date(2013, 10, 1, 14, 0, 0, 0, time.Local))
+	FailOnErr(err)
+	if Is(len(plan), 2) {
+		Is(readFile(plan[0].Path), "core 2")
+		Is(readFile(plan[1].Path), "hdfs 1")
+	}
+
+	removed, err := Prune(files, 1, 0, time.Now())
+	FailOnErr(err)
+	Is(len(removed), 4)
+	history, err = History(files)
+	FailOnErr(err)
+	Is(len(history), 1)
+	removed, err = Prune(files, -1, time.Hour, time.Now().Add(2*time.Hour))
+	FailOnErr(err)
+	Is(len(removed), 2)
+	Is(readFile(hdfs+".orig"), "not a backup")
+}
@@ -1,9 +1,13 @@
 package hadoopconf
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
 	"io/ioutil"
+	"math"
 	"os"
+	"sort"
 	"time"
 )
 
@@ -11,6 +15,7 @@ type Conf interface {
 	Keys() []string
 	Set(key, val string) (oldval string)
 	Get(key string) string
+	Delete(key string) (oldval string)
 }
 
 type Source struct {
@@ -24,6 +29,20 @@ type ConfSourcer interface {
 	Source() string
 }
 
+// finalSourcer is implemented by configurations which can tell
+// whether a key is marked with <final>true</final>, and where
+type finalSourcer interface {
+	FinalSource(key string) (src Source, ok bool)
+}
+
+// FinalSource returns the source in which key was declared final, if any.
+func FinalSource(cs ConfSourcer, key string) (src Source, ok bool) {
+	if fs, isFinalSourcer := cs.(finalSourcer); isFinalSourcer {
+		return fs.FinalSource(key)
+	}
+	return NoSource, false
+}
+
 type ConfWithDefault struct {
 	Conf    ConfSourcer
 	Default ConfSourcer
@@ -57,16 +76,32 @@ func (cwd *ConfWithDefault) Source() string {
 	return cwd.Conf.Source() + " default: " + cwd.Default.Source()
 }
 
+// SourceGet returns the value from the site configuration, unless
+// the default declared key as final, in which case site cannot override it.
 func (cwd *ConfWithDefault) SourceGet(key string) (value string, src Source) {
 	if cwd == nil {
 		return "", NoSource
 	}
+	if _, final := FinalSource(cwd.Default, key); final {
+		return sourceGet(cwd.Default, key)
+	}
 	if v, src := sourceGet(cwd.Conf, key); v != "" {
 		return v, src
 	}
 	return sourceGet(cwd.Default, key)
 }
 
+// FinalSource returns the default's source if key is final there, and otherwise the site's source
+func (cwd *ConfWithDefault) FinalSource(key string) (src Source, ok bool) {
+	if cwd == nil {
+		return NoSource, false
+	}
+	if src, ok := FinalSource(cwd.Default, key); ok {
+		return src, ok
+	}
+	return FinalSource(cwd.Conf, key)
+}
+
 func (cwd *ConfWithDefault) Get(key string) (value string) {
 	v, _ := cwd.SourceGet(key)
 	return v
@@ -78,45 +113,165 @@ func (cwd *ConfWithDefault) Set(key, value string) (oldval string) {
 	return oldval
 }
 
+// Delete removes key from the site configuration, so that the default is in effect again
+func (cwd *ConfWithDefault) Delete(key string) (oldval string) {
+	oldval = cwd.Get(key)
+	cwd.Conf.Delete(key)
+	return oldval
+}
+
 type Property struct {
 	Name        string `xml:"name"`
 	Value       string `xml:"value"`
 	Description string `xml:"description"`
+	Final       bool   `xml:"final,omitempty"`
+	raw         *rawProperty
 }
 
 type Configuration struct {
 	XMLName  xml.Name    `xml:"configuration"`
 	Property []*Property `xml:"property"`
+	// <xi:include> elements, the included properties are not part of Property
+	XIncludes []*XInclude `xml:"-"`
+	// the document the configuration was parsed from, nil for new configurations
+	doc *xmlDoc
 }
 
 type FileConfiguration struct {
 	*Configuration
 	Path     string
 	modified bool
+	// the file as it was read, to tell if someone else changed it before we save
+	stamp *fileStamp
+	// files included with <xi:include href="..."/>, in the order they appear
+	Includes []*FileConfiguration
+	// offset of the <xi:include> of an included file, in the file which includes it
+	at int
+	// why the file, as it was saved, could not be read back. It cannot be saved again, as what
+	// we have of it is not what is in the file.
+	unread error
 }
 
 func NewFileConfiguration(path string) (*FileConfiguration, error) {
-	if _, err := os.Open(path); os.IsNotExist(err) {
-		return &FileConfiguration{&Configuration{}, path, false}, nil
-	}
-	conf, err := NewConfigurationFromFile(path)
+	return newFileConfiguration(path, nil)
+}
+
+func newFileConfiguration(path string, including []string) (*FileConfiguration, error) {
+	content, stamp, err := readStamped(path)
 	if err != nil {
 		return nil, err
 	}
-	return &FileConfiguration{conf, path, false}, nil
+	fc := &FileConfiguration{Configuration: &Configuration{}, Path: path, stamp: stamp}
+	if !stamp.exists {
+		return fc, nil
+	}
+	if err := fc.load(content, append(including, path)); err != nil {
+		return nil, err
+	}
+	return fc, nil
+}
+
+// load parses content into fc, and reads the files it includes
+func (fc *FileConfiguration) load(content []byte, including []string) error {
+	conf, err := NewConfigurationFromByte(content)
+	if err != nil {
+		return err
+	}
+	loaded := &FileConfiguration{Configuration: conf, Path: fc.Path}
+	if err := loaded.resolveIncludes(including); err != nil {
+		return err
+	}
+	fc.Configuration, fc.Includes = loaded.Configuration, loaded.Includes
+	return nil
+}
+
+// definition is a property of a key in a file, at offset in the file fc reads it from
+type definition struct {
+	owner  *FileConfiguration
+	prop   *Property
+	offset int
+}
+
+// definitions returns the properties of key in fc and the files it includes, in the order hadoop
+// reads them: included properties are read in place of their <xi:include>
+func (fc *FileConfiguration) definitions(key string) []definition {
+	defs := []definition{}
+	for _, p := range fc.Property {
+		if p.Name == key {
+			defs = append(defs, definition{fc, p, p.offset()})
+		}
+	}
+	for _, inc := range fc.Includes {
+		for _, d := range inc.definitions(key) {
+			defs = append(defs, definition{d.owner, d.prop, inc.at})
+		}
+	}
+	sort.SliceStable(defs, func(i, j int) bool { return defs[i].offset < defs[j].offset })
+	return defs
+}
+
+// owner returns the file which holds the property of key hadoop uses, either fc or one of the
+// files it includes, and the property. As in hadoop, the last definition wins, unless an earlier
+// one is final. Both are nil if no file defines key.
+func (fc *FileConfiguration) owner(key string) (owner *FileConfiguration, prop *Property) {
+	for _, d := range fc.definitions(key) {
+		if prop != nil && prop.Final {
+			break
+		}
+		owner, prop = d.owner, d.prop
+	}
+	return owner, prop
 }
 
 func (fc *FileConfiguration) Keys() []string {
 	keys := []string{}
+	seen := make(map[string]bool)
 	for _, p := range fc.Property {
-		keys = append(keys, p.Name)
+		if !seen[p.Name] {
+			keys = append(keys, p.Name)
+			seen[p.Name] = true
+		}
+	}
+	for _, inc := range fc.Includes {
+		for _, key := range inc.Keys() {
+			if !seen[key] {
+				keys = append(keys, key)
+				seen[key] = true
+			}
+		}
 	}
 	return keys
 }
 
+func (fc *FileConfiguration) Get(key string) string {
+	v, _ := fc.SourceGet(key)
+	return v
+}
+
+// Set sets the value of key where hadoop reads it from, or adds it to fc if no file defines it
 func (fc *FileConfiguration) Set(key, val string) (oldval string) {
-	fc.modified = true
-	return fc.Configuration.Set(key, val)
+	owner, p := fc.owner(key)
+	if p == nil {
+		fc.modified = true
+		return fc.Configuration.Set(key, val)
+	}
+	owner.modified = true
+	oldval = p.Value
+	p.Value = val
+	return oldval
+}
+
+// Delete removes key from fc and from all files it includes
+func (fc *FileConfiguration) Delete(key string) (oldval string) {
+	oldval = fc.Get(key)
+	if fc.get(key) != nil {
+		fc.modified = true
+		fc.Configuration.Delete(key)
+	}
+	for _, inc := range fc.Includes {
+		inc.Delete(key)
+	}
+	return oldval
 }
 
 func (fc *FileConfiguration) Source() string {
@@ -124,27 +279,69 @@ func (fc *FileConfiguration) Source() string {
 }
 
 func (fc *FileConfiguration) SourceGet(key string) (value string, source Source) {
-	if p := fc.get(key); p != nil {
-		return p.Value, Source{fc.Path, LocalFile}
+	if owner, p := fc.owner(key); p != nil {
+		return p.Value, Source{owner.Path, LocalFile}
 	}
 	return "", NoSource
 }
 
+func (fc *FileConfiguration) FinalSource(key string) (src Source, ok bool) {
+	if fc == nil {
+		return NoSource, false
+	}
+	if owner, p := fc.owner(key); p != nil && p.Final {
+		return Source{owner.Path, LocalFile}, true
+	}
+	return NoSource, false
+}
+
 // Save saves the file configuration to hard drive, if backup = true will keep a backup
 func (fc *FileConfiguration) Save(backup bool) error {
-	if !fc.modified {
-		return nil
+	suffix := ""
+	if backup {
+		suffix = backupSuffix(time.Now())
 	}
-	if _, err := os.Stat(fc.Path); err != nil && !os.IsNotExist(err) {
+	tx := newTransaction(suffix)
+	if err := fc.stage(tx); err != nil {
+		tx.Abort()
 		return err
-	} else if !os.IsNotExist(err) {
-		os.Rename(fc.Path, fc.Path+time.Now().Format(".2006-01-02_15_04.000"))
 	}
-	if err := ioutil.WriteFile(fc.Path, fc.Bytes(), 0655); err != nil {
-		return err
+	return tx.Commit()
+}
+
+// stage stages fc and the files it includes, those which were modified, to be written by tx
+func (fc *FileConfiguration) stage(tx *transaction) error {
+	if fc.unread != nil {
+		return fc.unread
 	}
-	fc.modified = false
-	return nil
+	for _, inc := range fc.Includes {
+		if err := inc.stage(tx); err != nil {
+			return err
+		}
+	}
+	if !fc.modified {
+		return nil
+	}
+	content := fc.Bytes()
+	return tx.Write(fc.Path, fc.stamp, content, func(written []byte) error {
+		fc.modified = false
+		// merged with changes made by someone else, which we don't have yet
+		if !bytes.Equal(written, content) {
+			if err := fc.load(written, []string{fc.Path}); err != nil {
+				fc.unread = errors.New(fc.Path + ": " + err.Error() + ", read it again")
+				return fc.unread
+			}
+		}
+		return nil
+	})
+}
+
+// offset is where p is in the document it was read from, properties which were added are last
+func (p *Property) offset() int {
+	if p.raw == nil {
+		return math.MaxInt32
+	}
+	return p.raw.block.start
 }
 
 type GeneratedConf struct {
@@ -188,6 +385,13 @@ func (gc *GeneratedConf) SourceGet(key string) (value string, source Source) {
 	return "", NoSource
 }
 
+func (gc *GeneratedConf) FinalSource(key string) (src Source, ok bool) {
+	if gc != nil && gc.IsFinal(key) {
+		return gc.ConfSource, true
+	}
+	return NoSource, false
+}
+
 type SourceType int
 
 const (
@@ -196,6 +400,16 @@ const (
 	Generated
 )
 
+func (t SourceType) String() string {
+	switch t {
+	case LocalFile:
+		return "file"
+	case FileFromJar:
+		return "jar"
+	}
+	return "generated"
+}
+
 type multiSourceConf []ConfSourcer
 
 var NoSource = Source{}
@@ -214,7 +428,14 @@ func (msc multiSourceConf) Keys() []string {
 	return result
 }
 
+// SourceGet returns the value of the first configuration that has the key,
+// unless one of them declares it final.
 func (msc multiSourceConf) SourceGet(key string) (string, Source) {
+	for _, s := range msc {
+		if _, final := FinalSource(s, key); final {
+			return s.SourceGet(key)
+		}
+	}
 	for _, s := range msc {
 		v, src := s.SourceGet(key)
 		if src != NoSource {
@@ -224,31 +445,46 @@ func (msc multiSourceConf) SourceGet(key string) (string, Source) {
 	return "", NoSource
 }
 
+// FinalSource returns the source of the first configuration that declares key final
+func (msc multiSourceConf) FinalSource(key string) (src Source, ok bool) {
+	for _, s := range msc {
+		if src, ok := FinalSource(s, key); ok {
+			return src, ok
+		}
+	}
+	return NoSource, false
+}
+
 func (msc multiSourceConf) SetIfExist(key, value string) (oldval string, src ConfSourcer) {
 	for _, s := range msc {
 		if _, keysource := s.SourceGet(key); keysource != NoSource {
-			return s.Set(key, value), src
+			return s.Set(key, value), s
 		}
 	}
 	return "", nil
 }
 
+// get returns the property of key hadoop uses: the last one, unless an earlier one is final
 func (c *Configuration) get(key string) *Property {
 	if c == nil {
 		return nil
 	}
+	var last *Property
 	for _, prop := range c.Property {
 		if prop.Name == key {
-			return prop
+			if prop.Final {
+				return prop
+			}
+			last = prop
 		}
 	}
-	return nil
+	return last
 }
 
 func (c *Configuration) getOrAdd(key string) *Property {
 	v := c.get(key)
 	if v == nil {
-		v = &Property{key, "", ""}
+		v = &Property{Name: key}
 		c.Property = append(c.Property, v)
 	}
 	return v
@@ -261,6 +497,27 @@ func (c *Configuration) Set(key, val string) (oldval string) {
 	return oldval
 }
 
+// Delete removes all properties named key
+func (c *Configuration) Delete(key string) (oldval string) {
+	oldval = c.Get(key)
+	props := []*Property{}
+	for _, prop := range c.Property {
+		if prop.Name != key {
+			props = append(props, prop)
+		} else if prop.raw != nil {
+			prop.raw.deleted = true
+		}
+	}
+	c.Property = props
+	return oldval
+}
+
+// IsFinal returns true if the key is marked with <final>true</final>
+func (c *Configuration) IsFinal(key string) bool {
+	p := c.get(key)
+	return p != nil && p.Final
+}
+
 func (c *Configuration) Get(key string) string {
 	if n := c.get(key); n != nil {
 		return n.Value
@@ -268,7 +525,14 @@ func (c *Configuration) Get(key string) string {
 	return ""
 }
 
+// Bytes returns the configuration as XML. Configurations read from a file
+// are written as the original file, with only the modified properties changed.
 func (c *Configuration) Bytes() []byte {
+	if c.doc != nil {
+		if b := c.doc.render(c); b != nil {
+			return b
+		}
+	}
 	t, err := xml.MarshalIndent(c, "", "  ")
 	if err != nil {
 		panic(err) // should always be valid
@@ -281,11 +545,7 @@ func (c *Configuration) String() string {
 }
 
 func NewConfigurationFromByte(b []byte) (*Configuration, error) {
-	var c Configuration
-	if err := xml.Unmarshal(b, &c); err != nil {
-		return nil, err
-	}
-	return &c, nil
+	return parseConfiguration(b)
 }
 
 func NewConfigurationFromString(txt string) (*Configuration, error) {
@@ -44,3 +44,30 @@ func TestDefaultConf(t *testing.T) {
 	Is(v, "")
 	Is(src, NoSource)
 }
+
+func TestFinalConf(t *testing.T) {
+	Terst(t)
+	coreSite, err := NewGeneratedConfFromString(Source{"coreSite", Generated}, `<configuration>
+  <property><name>fs.final.in.site</name><value>site</value><final>true</final></property>
+  <property><name>fs.final.in.default</name><value>site</value></property>
+</configuration>`)
+	Is(err, nil)
+	coreDefault, err := NewGeneratedConfFromString(Source{"coreDefault", Generated}, `<configuration>
+  <property><name>fs.final.in.default</name><value>default</value><final> true </final></property>
+  <property><name>fs.final.in.site</name><value>default</value></property>
+</configuration>`)
+	Is(err, nil)
+	conf := &ConfWithDefault{coreSite, coreDefault}
+	ValSrc(conf.SourceGet("fs.final.in.default")).Is("default", "coreDefault")
+	ValSrc(conf.SourceGet("fs.final.in.site")).Is("site", "coreSite")
+	src, final := conf.FinalSource("fs.final.in.default")
+	Is(final, true)
+	Is(src.Source, "coreDefault")
+	_, final = FinalSource(coreDefault, "fs.final.in.site")
+	Is(final, false)
+
+	c, err := NewConfigurationFromString(coreSite.String())
+	Is(err, nil)
+	Is(c.IsFinal("fs.final.in.site"), true)
+	Is(c.IsFinal("fs.final.in.default"), false)
+}
@@ -0,0 +1,272 @@
+package hadoopconf
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ConfFile is a configuration file of hadoop, and where the default configuration it overrides is
+type ConfFile struct {
+	// Name of the file, such as core-site.xml
+	Name string
+	// Default is the name of the default configuration in the jar, empty if there's none
+	Default string
+	// Jar matches the names of the jars with the default configuration
+	Jar []*regexp.Regexp
+	// Dirs returns the directories the jar may be in, for the installation in basedir whose layout is l
+	Dirs func(l *Layout, basedir string) []string
+	// Template is a file next to the configuration file with its defaults, such as hive-default.xml.template
+	Template string
+	// ConfDirs returns the directories the file may be in, for files which aren't with hadoop's
+	// configuration in confDir. l is nil if the layout is none we know.
+	ConfDirs func(l *Layout, confDir string) []string
+	// Read reads the file, nil for xml configuration files
+	Read func(path string) (ConfSourcer, error)
+}
+
+// ConfFiles are the configuration files New reads, the *-site.xml of each project first
+var ConfFiles = []ConfFile{
+	{Name: "core-site.xml", Default: "core-default.xml", Jar: commonJar, Dirs: func(l *Layout, basedir string) []string {
+		return dirs(l.Common, basedir,
+			filepath.Join(basedir, "share/hadoop/common"),
+			"/usr/lib/hadoop",
+			"/share/hadoop/common")
+	}},
+	{Name: "hdfs-site.xml", Default: "hdfs-default.xml", Jar: hdfsJar, Dirs: func(l *Layout, basedir string) []string {
+		return dirs(l.Hdfs, basedir,
+			filepath.Join(basedir, "share/hadoop/hdfs"),
+			filepath.Join(basedir, "hadoop-hdfs"),
+			"/share/hadoop/hdfs",
+			"/usr/lib/hadoop-hdfs")
+	}},
+	{Name: "mapred-site.xml", Default: "mapred-default.xml", Jar: mapredJar, Dirs: func(l *Layout, basedir string) []string {
+		return dirs(l.Mapreduce, basedir,
+			filepath.Join(basedir, "hadoop-0.20-mapreduce"),
+			filepath.Join(basedir, "hadoop-mapreduce"),
+			filepath.Join(basedir, "share/hadoop/mapreduce"),
+			"/share/hadoop/mapreduce",
+			"/usr/lib/hadoop-0.20-mapreduce",
+			"/usr/lib/hadoop-mapreduce")
+	}},
+	{Name: "yarn-site.xml", Default: "yarn-default.xml", Jar: yarnJar, Dirs: func(l *Layout, basedir string) []string {
+		return dirs(l.Yarn, basedir,
+			filepath.Join(basedir, "share/hadoop/yarn"),
+			filepath.Join(basedir, "hadoop-yarn"),
+			"/usr/lib/hadoop-yarn",
+			"/share/hadoop/yarn")
+	}},
+	{Name: "capacity-scheduler.xml"},
+	{Name: "hadoop-policy.xml"},
+	{Name: "ssl-server.xml"},
+	{Name: "ssl-client.xml"},
+	// hadoop 3 has the kms and httpfs jars with the others, hadoop 2 in the webapp it runs in tomcat
+	{Name: "kms-site.xml", Default: "kms-default.xml", Jar: re(`^hadoop-kms-[0-9][-a-zA-Z0-9._]*\.jar$`), Dirs: func(l *Layout, basedir string) []string {
+		return dirs(l.Common, basedir,
+			filepath.Join(basedir, "share/hadoop/common"),
+			filepath.Join(l.Root, "share/hadoop/kms/tomcat/webapps/kms/WEB-INF/lib"),
+			filepath.Join(basedir, "share/hadoop/kms/tomcat/webapps/kms/WEB-INF/lib"))
+	}},
+	{Name: "httpfs-site.xml", Default: "httpfs-default.xml", Jar: re(`^hadoop-hdfs-httpfs-[0-9][-a-zA-Z0-9._]*\.jar$`), Dirs: func(l *Layout, basedir string) []string {
+		return dirs(l.Hdfs, basedir,
+			filepath.Join(basedir, "share/hadoop/hdfs"),
+			filepath.Join(l.Root, "share/hadoop/httpfs/tomcat/webapps/webhdfs/WEB-INF/lib"),
+			filepath.Join(basedir, "share/hadoop/httpfs/tomcat/webapps/webhdfs/WEB-INF/lib"))
+	}},
+	{Name: "hdfs-rbf-site.xml", Default: "hdfs-rbf-default.xml", Jar: re(`^hadoop-hdfs-rbf-[0-9][-a-zA-Z0-9._]*\.jar$`), Dirs: func(l *Layout, basedir string) []string {
+		return dirs(l.Hdfs, basedir, filepath.Join(basedir, "share/hadoop/hdfs"))
+	}},
+	// the ecosystem, whose configuration is in its own directory. hbase-default.xml is in hbase-common,
+	// or in the hbase jar before hbase 0.96.
+	{
+		Name: "hbase-site.xml", Default: "hbase-default.xml",
+		Jar:      re(`^hbase-common-[0-9][-a-zA-Z0-9._]*\.jar$`, `^hbase-[0-9][-a-zA-Z0-9._]*\.jar$`),
+		Dirs:     componentJarDirs("hbase", "HBASE_HOME"),
+		ConfDirs: componentConfDirs("hbase", "HBASE_CONF_DIR", "HBASE_HOME"),
+	},
+	// HiveConf has its defaults in code, hive-default.xml.template is generated from them
+	{Name: "hive-site.xml", Template: "hive-default.xml.template", ConfDirs: componentConfDirs("hive", "HIVE_CONF_DIR", "HIVE_HOME")},
+	{Name: "tez-site.xml", ConfDirs: componentConfDirs("tez", "TEZ_CONF_DIR", "TEZ_HOME")},
+	{Name: "spark-defaults.conf", ConfDirs: componentConfDirs("spark", "SPARK_CONF_DIR", "SPARK_HOME"), Read: readProperties(" ")},
+	{Name: "zoo.cfg", ConfDirs: componentConfDirs("zookeeper", "ZOOCFGDIR", "ZOOKEEPER_HOME"), Read: readProperties("=")},
+}
+
+// componentConfDirs returns where the configuration of an ecosystem component is: the directory
+// in confEnv, the conf directory of the component's home in homeEnv, where the distribution of l
+// keeps it, /etc/component/conf, and with hadoop's configuration last
+func componentConfDirs(component, confEnv, homeEnv string) func(l *Layout, confDir string) []string {
+	return func(l *Layout, confDir string) []string {
+		confs := []string{}
+		if dir := os.Getenv(confEnv); dir != "" {
+			confs = append(confs, dir)
+		}
+		if home := os.Getenv(homeEnv); home != "" {
+			confs = append(confs, filepath.Join(home, "conf"))
+		}
+		if l != nil {
+			confs = append(confs, componentDirs(l, component, "conf")...)
+		}
+		return append(confs, filepath.Join("/etc", component, "conf"), confDir)
+	}
+}
+
+// componentJarDirs returns where the jars of an ecosystem component are: the lib directory of the
+// component's home in homeEnv, or where the distribution of l keeps them
+func componentJarDirs(component, homeEnv string) func(l *Layout, basedir string) []string {
+	return func(l *Layout, basedir string) []string {
+		libs := []string{}
+		if home := os.Getenv(homeEnv); home != "" {
+			libs = append(libs, filepath.Join(home, "lib"))
+		}
+		if l.Name != "" {
+			libs = append(libs, componentDirs(l, component, "lib")...)
+		}
+		return append(libs, filepath.Join("/usr/lib", component, "lib"))
+	}
+}
+
+// componentDirs are the directories named dir of component, in each of the layouts which keep
+// the components next to hadoop: HDP's component-client, and the lib directory of bigtop and parcels
+func componentDirs(l *Layout, component, dir string) []string {
+	return []string{
+		filepath.Join(l.Root, component+"-client", dir),
+		filepath.Join(l.Root, component, dir),
+		filepath.Join(l.Root, "lib", component, dir),
+	}
+}
+
+// readProperties reads java properties files, sep separates keys from values of added properties
+func readProperties(sep string) func(path string) (ConfSourcer, error) {
+	return func(path string) (ConfSourcer, error) {
+		return NewPropertiesConf(path, sep)
+	}
+}
+
+// read reads the file, from the first of dirs which has it. If none has it, the file is read as
+// empty in the first directory which exists. exists is false then.
+func (f ConfFile) read(dirs []string) (conf ConfSourcer, exists bool, err error) {
+	dir := ""
+	for _, d := range dirs {
+		if hasFile(f.Name, d) {
+			dir, exists = d, true
+			break
+		}
+		if dir == "" && isDir(d) {
+			dir = d
+		}
+	}
+	if dir == "" {
+		return nil, false, errors.New("cannot find file " + f.Name + " in any of " + strings.Join(dirs, ", "))
+	}
+	path := filepath.Join(dir, f.Name)
+	if f.Read != nil {
+		conf, err = f.Read(path)
+	} else {
+		conf, err = NewFileConfiguration(path)
+	}
+	return conf, exists, err
+}
+
+// template reads the defaults in the template next to path, nil if there's none
+func (f ConfFile) template(path string) ConfSourcer {
+	if f.Template == "" {
+		return nil
+	}
+	template := filepath.Join(filepath.Dir(path), f.Template)
+	b, err := ioutil.ReadFile(template)
+	if err != nil {
+		return nil
+	}
+	dflt, err := NewGeneratedConfFromBytes(Source{template, Generated}, b)
+	if err != nil {
+		return nil
+	}
+	return dflt
+}
+
+// RegisterConfFile adds a configuration file for New to read
+func RegisterConfFile(f ConfFile) {
+	ConfFiles = append(ConfFiles, f)
+}
+
+// Site is a configuration file, such as core-site.xml, with its default
+type Site struct {
+	Name string
+	*ConfWithDefault
+}
+
+// Site returns the configuration file name, core-site or core-site.xml, nil if c has no such file
+func (c *HadoopConf) Site(name string) *Site {
+	for _, site := range c.Sites {
+		if site.Name == name || strings.TrimSuffix(site.Name, ".xml") == name {
+			return site
+		}
+	}
+	return nil
+}
+
+// AddSite adds a configuration file to c. Hadoop's daemons read it on its own, so its keys are
+// not among those c looks up, reach them through Site or Only.
+func (c *HadoopConf) AddSite(name string, conf *ConfWithDefault) *Site {
+	site := &Site{name, conf}
+	c.Sites = append(c.Sites, site)
+	return site
+}
+
+// HadoopSites returns the files whose keys c looks up, of those there are: the core, hdfs, mapred
+// and yarn sites, which hadoop's daemons read together, and not those added with AddSite
+func (c *HadoopConf) HadoopSites() []*Site {
+	sites := []*Site{}
+	for _, site := range c.Sites {
+		if site.Conf != nil && c.looksUp(site.ConfWithDefault) {
+			sites = append(sites, site)
+		}
+	}
+	return sites
+}
+
+// Lookup returns where the references in conf, one of the files of c, are looked up: c for the
+// files whose keys c looks up, and conf alone for the other files
+func (c *HadoopConf) Lookup(conf *ConfWithDefault) SourceGetter {
+	if c.looksUp(conf) {
+		return c
+	}
+	return conf
+}
+
+func (c *HadoopConf) looksUp(conf *ConfWithDefault) bool {
+	for _, s := range c.multiSourceConf {
+		if s == ConfSourcer(conf) {
+			return true
+		}
+	}
+	return false
+}
+
+// SitesOf returns the configuration files in which key is set, or which have a default for it
+func (c *HadoopConf) SitesOf(key string) []*Site {
+	sites := []*Site{}
+	for _, site := range c.Sites {
+		if _, src := site.SourceGet(key); src != NoSource {
+			sites = append(sites, site)
+		}
+	}
+	return sites
+}
+
+// Only returns a configuration with the file name of c only. It shares the file with c, so
+// what is set in one is set in the other.
+func (c *HadoopConf) Only(name string) (*HadoopConf, error) {
+	site := c.Site(name)
+	if site == nil {
+		names := []string{}
+		for _, site := range c.Sites {
+			names = append(names, site.Name)
+		}
+		return nil, errors.New("no configuration file " + name + ", try " + strings.Join(names, ", "))
+	}
+	return &HadoopConf{multiSourceConf: multiSourceConf{site.ConfWithDefault}, Layout: c.Layout, Sites: []*Site{site}}, nil
+}
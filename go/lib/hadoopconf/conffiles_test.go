@@ -0,0 +1,138 @@
+package hadoopconf
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+const sslClient = `<configuration>
+  <property><name>ssl.client.truststore.location</name><value>/etc/client.jks</value></property>
+  <property><name>ssl.keystore.type</name><value>jks</value></property>
+</configuration>
+`
+
+func TestConfFiles(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"etc/hadoop/core-site.xml":          "<configuration/>",
+		"etc/hadoop/hdfs-site.xml":          "<configuration/>",
+		"etc/hadoop/capacity-scheduler.xml": `<configuration><property><name>yarn.scheduler.capacity.root.queues</name><value>a,b</value></property></configuration>`,
+		"etc/hadoop/ssl-server.xml":         `<configuration><property><name>ssl.keystore.type</name><value>jks</value></property></configuration>`,
+		"etc/hadoop/ssl-client.xml":         sslClient,
+	})
+	defer os.RemoveAll(dir)
+	fakeLayout(dir, "3.3.6", "share/hadoop/common", "share/hadoop/hdfs", "share/hadoop/mapreduce", "share/hadoop/yarn")
+	writeJar(filepath.Join(dir, "share/hadoop/common/hadoop-kms-3.3.6.jar"), "kms-default.xml")
+	writeJar(filepath.Join(dir, "share/hadoop/hdfs/hadoop-hdfs-rbf-3.3.6.jar"), "hdfs-rbf-default.xml")
+
+	jars, err := Jars(dir)
+	FailOnErr(err)
+	c, err := New(dir, jars)
+	FailOnErr(err)
+	names := []string{}
+	for _, site := range c.Sites {
+		names = append(names, site.Name)
+	}
+	// hadoop-policy.xml and httpfs-site.xml are neither there nor have a default
+	Is(strings.Join(names, " "), "core-site.xml hdfs-site.xml mapred-site.xml yarn-site.xml "+
+		"capacity-scheduler.xml ssl-server.xml ssl-client.xml kms-site.xml hdfs-rbf-site.xml")
+	Is(c.Site("capacity-scheduler").Get("yarn.scheduler.capacity.root.queues"), "a,b")
+	Is(c.Site("kms-site").Get("kms-default.xml"), "hadoop-kms-3.3.6.jar")
+	Is(c.Site("hdfs-rbf-site").Get("hdfs-rbf-default.xml"), "hadoop-hdfs-rbf-3.3.6.jar")
+	// hadoop's daemons read them on their own, not with the core, hdfs, mapred and yarn sites
+	_, src := c.SourceGet("yarn.scheduler.capacity.root.queues")
+	Is(src, NoSource)
+	Is(len(c.HadoopSites()), 4)
+	Is(c.Lookup(c.Site("ssl-server").ConfWithDefault), SourceGetter(c.Site("ssl-server").ConfWithDefault))
+	Is(c.Lookup(c.Site("hdfs-site").ConfWithDefault), SourceGetter(c))
+
+	sites := c.SitesOf("ssl.keystore.type")
+	if Is(len(sites), 2) {
+		Is(sites[0].Name, "ssl-server.xml")
+		Is(sites[1].Name, "ssl-client.xml")
+	}
+	// the first file which has the key is set, unless we choose one
+	site, _, err := c.SiteFor("ssl.keystore.type")
+	FailOnErr(err)
+	Is(site.Set("ssl.keystore.type", "pkcs12"), "jks")
+	client, err := c.Only("ssl-client.xml")
+	FailOnErr(err)
+	keys := client.Keys()
+	sort.Strings(keys)
+	Is(strings.Join(keys, " "), "ssl.client.truststore.location ssl.keystore.type")
+	old, _ := client.SetIfExist("ssl.keystore.type", "jceks")
+	Is(old, "jks")
+	Is(c.Site("ssl-client").Get("ssl.keystore.type"), "jceks")
+	FailOnErr(client.Save(false))
+	Is(readFile(filepath.Join(dir, "etc/hadoop/ssl-client.xml")), strings.Replace(sslClient, ">jks<", ">jceks<", 1))
+	Is(c.Site("ssl-server").Get("ssl.keystore.type"), "pkcs12")
+
+	_, err = c.Only("hadoop-policy.xml")
+	IsNot(err, nil)
+}
+
+func TestEcosystemConfFiles(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"etc/hadoop/core-site.xml":            "<configuration/>",
+		"etc/hadoop/hdfs-site.xml":            "<configuration/>",
+		"hbase/conf/hbase-site.xml":           `<configuration><property><name>hbase.rootdir</name><value>hdfs://nn/hbase</value></property></configuration>`,
+		"hive/conf/hive-site.xml":             `<configuration><property><name>hive.metastore.uris</name><value>thrift://ms:9083</value></property></configuration>`,
+		"hive/conf/hive-default.xml.template": `<configuration><property><name>hive.exec.scratchdir</name><value>/tmp/hive</value></property></configuration>`,
+		"spark/conf/spark-defaults.conf":      "spark.master yarn\n",
+		"zookeeper/conf/zoo.cfg":              "tickTime=2000\n",
+	})
+	defer os.RemoveAll(dir)
+	fakeLayout(dir, "3.3.6", "share/hadoop/common", "share/hadoop/hdfs", "share/hadoop/mapreduce", "share/hadoop/yarn")
+	writeJar(filepath.Join(dir, "opt/hbase-2.5.0/lib/hbase-common-2.5.0.jar"), "hbase-default.xml")
+	for env, value := range map[string]string{
+		"HBASE_CONF_DIR": filepath.Join(dir, "hbase/conf"),
+		"HBASE_HOME":     filepath.Join(dir, "opt/hbase-2.5.0"),
+		"HIVE_CONF_DIR":  filepath.Join(dir, "hive/conf"),
+		"SPARK_HOME":     filepath.Join(dir, "spark"),
+		"ZOOCFGDIR":      filepath.Join(dir, "zookeeper/conf"),
+	} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Setenv(env, value)
+	}
+
+	jars, err := Jars(dir)
+	FailOnErr(err)
+	c, err := New(dir, jars)
+	FailOnErr(err)
+	Is(c.Site("hbase-site").Source(), filepath.Join(dir, "hbase/conf/hbase-site.xml")+
+		" default: "+filepath.Join(dir, "opt/hbase-2.5.0/lib/hbase-common-2.5.0.jar/hbase-default.xml"))
+	Is(c.Site("tez-site"), (*Site)(nil))
+	for key, expected := range map[string][2]string{
+		"hbase.rootdir":        {"hbase-site", "hdfs://nn/hbase"},
+		"hbase-default.xml":    {"hbase-site", "hbase-common-2.5.0.jar"},
+		"hive.metastore.uris":  {"hive-site", "thrift://ms:9083"},
+		"hive.exec.scratchdir": {"hive-site", "/tmp/hive"},
+		"spark.master":         {"spark-defaults.conf", "yarn"},
+		"tickTime":             {"zoo.cfg", "2000"},
+	} {
+		Is(c.Site(expected[0]).Get(key), expected[1])
+		// their keys are not hadoop's
+		_, src := c.SourceGet(key)
+		Is(src, NoSource)
+	}
+	// nor can they satisfy references in hadoop's files
+	v, err := c.Expand("${tickTime}")
+	FailOnErr(err)
+	Is(v, "${tickTime}")
+	_, src := c.Site("hive-site").SourceGet("hive.exec.scratchdir")
+	Is(src.SourceType, Generated)
+
+	// properties files are saved with the rest
+	c.Site("spark-defaults.conf").Set("spark.master", "local")
+	c.Site("hive-site").Set("hive.metastore.uris", "thrift://ms2:9083")
+	FailOnErr(c.Save(false))
+	Is(readFile(filepath.Join(dir, "spark/conf/spark-defaults.conf")), "spark.master local\n")
+	Is(strings.Contains(readFile(filepath.Join(dir, "hive/conf/hive-site.xml")), "thrift://ms2:9083"), true)
+	Is(strings.Contains(strings.Join(c.Files(), " "), "zoo.cfg"), true)
+}
@@ -0,0 +1,109 @@
+package hadoopconf
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// ConflictPolicy is what Save does with files which changed on disk since they were read
+type ConflictPolicy int
+
+const (
+	// Refuse fails the save with a *ConflictError
+	Refuse ConflictPolicy = iota
+	// Merge merges the changes made on disk with ours, and fails with a *ConflictError
+	// if they touch the same lines
+	Merge
+	// Overwrite replaces the changes made on disk by ours
+	Overwrite
+)
+
+// OnConflict is the policy of Save for files changed by someone else
+var OnConflict = Refuse
+
+// ConflictError is returned by Save when a file changed since it was read, no file is saved then
+type ConflictError struct {
+	Path string
+	// a merge was attempted, but both changed the same lines
+	Merged bool
+}
+
+func (e *ConflictError) Error() string {
+	if e.Merged {
+		return e.Path + " changed since it was read, and the changes conflict with ours"
+	}
+	return e.Path + " changed since it was read"
+}
+
+// fileStamp is a file as it was read, to tell if it changed since
+type fileStamp struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+	sum     [sha1.Size]byte
+	content []byte
+}
+
+// readStamped reads path, a missing file is read as empty
+func readStamped(path string) ([]byte, *fileStamp, error) {
+	stamp := &fileStamp{}
+	if err := stamp.read(path); err != nil {
+		return nil, nil, err
+	}
+	return stamp.content, stamp, nil
+}
+
+func (stamp *fileStamp) read(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		*stamp = fileStamp{sum: sha1.Sum(nil)}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	*stamp = fileStamp{true, info.ModTime(), info.Size(), sha1.Sum(content), content}
+	return nil
+}
+
+// changed returns the current content of path if it differs from the stamp.
+// A file whose modification time changed, but not its content, is unchanged.
+func (stamp *fileStamp) changed(path string) (current []byte, changed bool, err error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, stamp.exists, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	if stamp.exists && info.ModTime().Equal(stamp.modTime) && info.Size() == stamp.size {
+		return nil, false, nil
+	}
+	current, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	return current, !stamp.exists || sha1.Sum(current) != stamp.sum, nil
+}
+
+// resolve returns what to write instead of ours, given that the file now has current
+func (stamp *fileStamp) resolve(path string, ours, current []byte) ([]byte, error) {
+	switch OnConflict {
+	case Overwrite:
+		return ours, nil
+	case Merge:
+		if bytes.Equal(ours, current) {
+			return ours, nil
+		}
+		if merged, ok := Merge3(string(stamp.content), string(ours), string(current)); ok {
+			return []byte(merged), nil
+		}
+		return nil, &ConflictError{path, true}
+	}
+	return nil, &ConflictError{path, false}
+}
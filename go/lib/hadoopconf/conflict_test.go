@@ -0,0 +1,155 @@
+package hadoopconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestMerge3(t *testing.T) {
+	Terst(t)
+	base := "a\nb\nc\nd\ne"
+	merged, ok := Merge3(base, "A\nb\nc\nd\ne", "a\nb\nc\nd\nE")
+	Is(ok, true)
+	Is(merged, "A\nb\nc\nd\nE")
+	merged, ok = Merge3(base, "a\nb\nc\nd\ne\nf", "a\nc\nd\ne")
+	Is(ok, true)
+	Is(merged, "a\nc\nd\ne\nf")
+	// the same change on both sides is not a conflict
+	merged, ok = Merge3(base, "a\nB\nc\nd\ne", "a\nB\nc\nd\ne")
+	Is(ok, true)
+	Is(merged, "a\nB\nc\nd\ne")
+	_, ok = Merge3(base, "a\nB\nc\nd\ne", "a\nX\nc\nd\ne")
+	Is(ok, false)
+	// adjacent lines are a conflict too
+	_, ok = Merge3(base, "a\nB\nc\nd\ne", "a\nb\nC\nd\ne")
+	Is(ok, false)
+}
+
+const conflictSite = `<configuration>
+  <property>
+    <name>dfs.replication</name>
+    <value>3</value>
+  </property>
+  <property>
+    <name>dfs.blocksize</name>
+    <value>128m</value>
+  </property>
+</configuration>
+`
+
+// changeOnDisk writes path as someone else would, making sure its modification time changes
+func changeOnDisk(path, from, to string) {
+	content := strings.Replace(readFile(path), from, to, 1)
+	FailOnErr(ioutil.WriteFile(path, []byte(content), 0644))
+	later := time.Now().Add(time.Minute)
+	FailOnErr(os.Chtimes(path, later, later))
+}
+
+func TestSaveConflict(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hdfs-site.xml": conflictSite})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hdfs-site.xml")
+	defer func(policy ConflictPolicy) { OnConflict = policy }(OnConflict)
+
+	// touched, but not changed
+	fc, err := NewFileConfiguration(path)
+	FailOnErr(err)
+	changeOnDisk(path, "", "")
+	fc.Set("dfs.replication", "2")
+	FailOnErr(fc.Save(false))
+	Is(strings.Contains(readFile(path), "<value>2</value>"), true)
+
+	fc, err = NewFileConfiguration(path)
+	FailOnErr(err)
+	changeOnDisk(path, "128m", "256m")
+	fc.Set("dfs.replication", "1")
+	OnConflict = Refuse
+	err = fc.Save(false)
+	if conflict, ok := err.(*ConflictError); Is(ok, true) {
+		Is(conflict.Path, path)
+		Is(conflict.Merged, false)
+	}
+	Is(strings.Contains(readFile(path), "<value>2</value>"), true)
+	matches, _ := filepath.Glob(filepath.Join(dir, ".*"))
+	Is(len(matches), 0)
+
+	OnConflict = Merge
+	FailOnErr(fc.Save(false))
+	Is(strings.Contains(readFile(path), "<value>1</value>"), true)
+	Is(strings.Contains(readFile(path), "<value>256m</value>"), true)
+	// the merged changes are ours now, and the next save is based on them
+	Is(fc.Get("dfs.blocksize"), "256m")
+	fc.Set("dfs.blocksize", "512m")
+	FailOnErr(fc.Save(false))
+	Is(strings.Contains(readFile(path), "<value>1</value>"), true)
+
+	changeOnDisk(path, "<value>1</value>", "<value>5</value>")
+	fc.Set("dfs.replication", "4")
+	err = fc.Save(false)
+	if conflict, ok := err.(*ConflictError); Is(ok, true) {
+		Is(conflict.Merged, true)
+	}
+	OnConflict = Overwrite
+	FailOnErr(fc.Save(false))
+	Is(strings.Contains(readFile(path), "<value>4</value>"), true)
+}
+
+func TestSaveEnvConflict(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hadoop-env.sh": "export JAVA_HOME=/usr/java\n\nexport HADOOP_HEAPSIZE=1000\n"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hadoop-env.sh")
+	defer func(policy ConflictPolicy) { OnConflict = policy }(OnConflict)
+	env, err := NewEnvFromFile(path)
+	FailOnErr(err)
+	changeOnDisk(path, "1000", "2000")
+	env.Get("JAVA_HOME").SetVal("/opt/java")
+	OnConflict = Refuse
+	_, ok := env.Save(false).(*ConflictError)
+	Is(ok, true)
+	OnConflict = Merge
+	FailOnErr(env.Save(false))
+	Is(readFile(path), "export JAVA_HOME=/opt/java\n\nexport HADOOP_HEAPSIZE=2000\n")
+	Is(env.Get("HADOOP_HEAPSIZE").GetVal(), "2000")
+}
+
+func TestSaveMergedUnreadable(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"hdfs-site.xml": conflictSite,
+		"hadoop-env.sh": "export JAVA_HOME=/usr/java\n\nexport HADOOP_HEAPSIZE=1000\n\n\nexport HADOOP_OPTS=\n",
+	})
+	defer os.RemoveAll(dir)
+	path, envPath := filepath.Join(dir, "hdfs-site.xml"), filepath.Join(dir, "hadoop-env.sh")
+	defer func(policy ConflictPolicy) { OnConflict = policy }(OnConflict)
+	OnConflict = Merge
+
+	// someone else breaks the file, far enough from our change to merge
+	fc, err := NewFileConfiguration(path)
+	FailOnErr(err)
+	changeOnDisk(path, "</configuration>", "</configuration")
+	fc.Set("dfs.replication", "2")
+	IsNot(fc.Save(false), nil)
+	Is(strings.Contains(readFile(path), "<value>2</value>"), true)
+	// what we have is not what is in the file, it is not saved again
+	fc.Set("dfs.blocksize", "256m")
+	IsNot(fc.Save(false), nil)
+	Is(strings.Contains(readFile(path), "256m"), false)
+
+	env, err := NewEnvFromFile(envPath)
+	FailOnErr(err)
+	changeOnDisk(envPath, "HADOOP_OPTS=", `HADOOP_OPTS="-Dunterminated`)
+	env.Get("JAVA_HOME").SetVal("/opt/java")
+	IsNot(env.Save(false), nil)
+	Is(strings.HasPrefix(readFile(envPath), "export JAVA_HOME=/opt/java\n"), true)
+	env.Get("HADOOP_HEAPSIZE").SetVal("4000")
+	IsNot(env.Save(false), nil)
+	Is(strings.Contains(readFile(envPath), "4000"), false)
+}
@@ -0,0 +1,58 @@
+package hadoopconf
+
+import (
+	"strings"
+)
+
+// Daemon is a process hadoop's scripts launch, and the variable with its java options
+type Daemon struct {
+	Name string
+	Opts string
+	// Java is the variable the scripts add Opts to, and give java, such as HADOOP_OPTS
+	Java string
+	// Files are the env files the scripts source before they start the daemon, in order
+	Files []string
+	// Heap are the variables with the heap size in MB, later ones override earlier ones, and
+	// the -Xmx1000m of the scripts
+	Heap []string
+}
+
+var (
+	hadoopEnvFiles = []string{"hadoop-env.sh"}
+	yarnEnvFiles   = []string{"hadoop-env.sh", "yarn-env.sh"}
+	mapredEnvFiles = []string{"hadoop-env.sh", "mapred-env.sh"}
+	hadoopHeap     = []string{"HADOOP_HEAPSIZE"}
+)
+
+// yarnHeap returns the heap size variables of a yarn daemon
+func yarnHeap(daemon string) []string {
+	return []string{"YARN_HEAPSIZE", "YARN_" + daemon + "_HEAPSIZE"}
+}
+
+// Daemons are the hadoop processes, and clients, which read java options from env files
+var Daemons = []Daemon{
+	{"namenode", "HADOOP_NAMENODE_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+	{"secondarynamenode", "HADOOP_SECONDARYNAMENODE_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+	{"datanode", "HADOOP_DATANODE_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+	{"journalnode", "HADOOP_JOURNALNODE_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+	{"zkfc", "HADOOP_ZKFC_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+	{"balancer", "HADOOP_BALANCER_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+	{"jobtracker", "HADOOP_JOBTRACKER_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+	{"tasktracker", "HADOOP_TASKTRACKER_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+	{"resourcemanager", "YARN_RESOURCEMANAGER_OPTS", "YARN_OPTS", yarnEnvFiles, yarnHeap("RESOURCEMANAGER")},
+	{"nodemanager", "YARN_NODEMANAGER_OPTS", "YARN_OPTS", yarnEnvFiles, yarnHeap("NODEMANAGER")},
+	{"proxyserver", "YARN_PROXYSERVER_OPTS", "YARN_OPTS", yarnEnvFiles, yarnHeap("PROXYSERVER")},
+	{"timelineserver", "YARN_TIMELINESERVER_OPTS", "YARN_OPTS", yarnEnvFiles, yarnHeap("TIMELINESERVER")},
+	{"historyserver", "HADOOP_JOB_HISTORYSERVER_OPTS", "HADOOP_OPTS", mapredEnvFiles, []string{"HADOOP_HEAPSIZE", "HADOOP_JOB_HISTORYSERVER_HEAPSIZE"}},
+	{"client", "HADOOP_CLIENT_OPTS", "HADOOP_OPTS", hadoopEnvFiles, hadoopHeap},
+}
+
+// FindDaemon returns the daemon with the given name, case insensitive
+func FindDaemon(name string) (Daemon, bool) {
+	for _, d := range Daemons {
+		if strings.EqualFold(d.Name, name) {
+			return d, true
+		}
+	}
+	return Daemon{}, false
+}
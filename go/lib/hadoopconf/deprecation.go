@@ -0,0 +1,205 @@
+package hadoopconf
+
+import (
+	"sort"
+)
+
+// DeprecatedKeys maps keys hadoop 2 deprecated to their current names.
+// Hadoop still reads the deprecated names, but warns about them.
+var DeprecatedKeys = map[string]string{
+	// core
+	"fs.default.name":                   "fs.defaultFS",
+	"dfs.umaskmode":                     "fs.permissions.umask-mode",
+	"hadoop.native.lib":                 "io.native.lib.available",
+	"io.bytes.per.checksum":             "dfs.bytes-per-checksum",
+	"topology.script.file.name":         "net.topology.script.file.name",
+	"topology.script.number.args":       "net.topology.script.number.args",
+	"topology.node.switch.mapping.impl": "net.topology.node.switch.mapping.impl",
+	"hadoop.configured.node.mapping":    "net.topology.configured.node.mapping",
+	"fs.checkpoint.dir":                 "dfs.namenode.checkpoint.dir",
+	"fs.checkpoint.edits.dir":           "dfs.namenode.checkpoint.edits.dir",
+	"fs.checkpoint.period":              "dfs.namenode.checkpoint.period",
+	"session.id":                        "dfs.metrics.session-id",
+	"slave.host.name":                   "dfs.datanode.hostname",
+	// hdfs
+	"dfs.access.time.precision":           "dfs.namenode.accesstime.precision",
+	"dfs.backup.address":                  "dfs.namenode.backup.address",
+	"dfs.backup.http.address":             "dfs.namenode.backup.http-address",
+	"dfs.balance.bandwidthPerSec":         "dfs.datanode.balance.bandwidthPerSec",
+	"dfs.block.size":                      "dfs.blocksize",
+	"dfs.data.dir":                        "dfs.datanode.data.dir",
+	"dfs.datanode.max.xcievers":           "dfs.datanode.max.transfer.threads",
+	"dfs.df.interval":                     "fs.df.interval",
+	"dfs.http.address":                    "dfs.namenode.http-address",
+	"dfs.https.address":                   "dfs.namenode.https-address",
+	"dfs.https.client.keystore.resource":  "dfs.client.https.keystore.resource",
+	"dfs.https.need.client.auth":          "dfs.client.https.need-auth",
+	"dfs.max.objects":                     "dfs.namenode.max.objects",
+	"dfs.max-repl-streams":                "dfs.namenode.replication.max-streams",
+	"dfs.name.dir":                        "dfs.namenode.name.dir",
+	"dfs.name.dir.restore":                "dfs.namenode.name.dir.restore",
+	"dfs.name.edits.dir":                  "dfs.namenode.edits.dir",
+	"dfs.permissions":                     "dfs.permissions.enabled",
+	"dfs.permissions.supergroup":          "dfs.permissions.superusergroup",
+	"dfs.read.prefetch.size":              "dfs.client.read.prefetch.size",
+	"dfs.replication.considerLoad":        "dfs.namenode.replication.considerLoad",
+	"dfs.replication.interval":            "dfs.namenode.replication.interval",
+	"dfs.replication.min":                 "dfs.namenode.replication.min",
+	"dfs.replication.pending.timeout.sec": "dfs.namenode.replication.pending.timeout-sec",
+	"dfs.safemode.extension":              "dfs.namenode.safemode.extension",
+	"dfs.safemode.threshold.pct":          "dfs.namenode.safemode.threshold-pct",
+	"dfs.secondary.http.address":          "dfs.namenode.secondary.http-address",
+	"dfs.socket.timeout":                  "dfs.client.socket-timeout",
+	"dfs.upgrade.permission":              "dfs.namenode.upgrade.permission",
+	"dfs.write.packet.size":               "dfs.client-write-packet-size",
+	// mapreduce
+	"mapred.job.tracker":                          "mapreduce.jobtracker.address",
+	"mapred.job.tracker.http.address":             "mapreduce.jobtracker.http.address",
+	"mapred.job.tracker.handler.count":            "mapreduce.jobtracker.handler.count",
+	"mapred.job.tracker.persist.jobstatus.active": "mapreduce.jobtracker.persist.jobstatus.active",
+	"mapred.job.tracker.retiredjobs.cache.size":   "mapreduce.jobtracker.retiredjobs.cache.size",
+	"mapred.jobtracker.taskScheduler":             "mapreduce.jobtracker.taskscheduler",
+	"mapred.jobtracker.restart.recover":           "mapreduce.jobtracker.restart.recover",
+	"mapred.heartbeats.in.second":                 "mapreduce.jobtracker.heartbeats.in.second",
+	"mapred.hosts":                                "mapreduce.jobtracker.hosts.filename",
+	"mapred.hosts.exclude":                        "mapreduce.jobtracker.hosts.exclude.filename",
+	"mapred.system.dir":                           "mapreduce.jobtracker.system.dir",
+	"mapred.task.tracker.http.address":            "mapreduce.tasktracker.http.address",
+	"mapred.task.tracker.report.address":          "mapreduce.tasktracker.report.address",
+	"mapred.tasktracker.map.tasks.maximum":        "mapreduce.tasktracker.map.tasks.maximum",
+	"mapred.tasktracker.reduce.tasks.maximum":     "mapreduce.tasktracker.reduce.tasks.maximum",
+	"mapred.tasktracker.dns.interface":            "mapreduce.tasktracker.dns.interface",
+	"mapred.tasktracker.dns.nameserver":           "mapreduce.tasktracker.dns.nameserver",
+	"mapred.healthChecker.script.path":            "mapreduce.tasktracker.healthchecker.script.path",
+	"tasktracker.http.threads":                    "mapreduce.tasktracker.http.threads",
+	"mapred.local.dir":                            "mapreduce.cluster.local.dir",
+	"mapred.temp.dir":                             "mapreduce.cluster.temp.dir",
+	"mapred.acls.enabled":                         "mapreduce.cluster.acls.enabled",
+	"mapred.map.tasks":                            "mapreduce.job.maps",
+	"mapred.reduce.tasks":                         "mapreduce.job.reduces",
+	"mapred.job.name":                             "mapreduce.job.name",
+	"mapred.job.queue.name":                       "mapreduce.job.queuename",
+	"mapred.job.priority":                         "mapreduce.job.priority",
+	"mapred.working.dir":                          "mapreduce.job.working.dir",
+	"mapred.job.reuse.jvm.num.tasks":              "mapreduce.job.jvm.numtasks",
+	"mapred.reduce.slowstart.completed.maps":      "mapreduce.job.reduce.slowstart.completedmaps",
+	"mapred.userlog.retain.hours":                 "mapreduce.job.userlog.retain.hours",
+	"mapred.skip.on":                              "mapreduce.job.skiprecords",
+	"job.end.notification.url":                    "mapreduce.job.end-notification.url",
+	"mapred.map.max.attempts":                     "mapreduce.map.maxattempts",
+	"mapred.reduce.max.attempts":                  "mapreduce.reduce.maxattempts",
+	"mapred.map.tasks.speculative.execution":      "mapreduce.map.speculative",
+	"mapred.reduce.tasks.speculative.execution":   "mapreduce.reduce.speculative",
+	"mapred.map.child.java.opts":                  "mapreduce.map.java.opts",
+	"mapred.reduce.child.java.opts":               "mapreduce.reduce.java.opts",
+	"mapred.job.map.memory.mb":                    "mapreduce.map.memory.mb",
+	"mapred.job.reduce.memory.mb":                 "mapreduce.reduce.memory.mb",
+	"mapred.compress.map.output":                  "mapreduce.map.output.compress",
+	"mapred.map.output.compression.codec":         "mapreduce.map.output.compress.codec",
+	"mapred.output.compress":                      "mapreduce.output.fileoutputformat.compress",
+	"mapred.output.compression.codec":             "mapreduce.output.fileoutputformat.compress.codec",
+	"mapred.output.compression.type":              "mapreduce.output.fileoutputformat.compress.type",
+	"mapred.output.dir":                           "mapreduce.output.fileoutputformat.outputdir",
+	"mapred.input.dir":                            "mapreduce.input.fileinputformat.inputdir",
+	"mapred.min.split.size":                       "mapreduce.input.fileinputformat.split.minsize",
+	"mapred.max.split.size":                       "mapreduce.input.fileinputformat.split.maxsize",
+	"mapred.task.timeout":                         "mapreduce.task.timeout",
+	"mapred.task.profile":                         "mapreduce.task.profile",
+	"mapred.child.tmp":                            "mapreduce.task.tmp.dir",
+	"mapred.userlog.limit.kb":                     "mapreduce.task.userlog.limit.kb",
+	"keep.failed.task.files":                      "mapreduce.task.files.preserve.failedtasks",
+	"io.sort.mb":                                  "mapreduce.task.io.sort.mb",
+	"io.sort.factor":                              "mapreduce.task.io.sort.factor",
+	"io.sort.spill.percent":                       "mapreduce.map.sort.spill.percent",
+	"mapred.job.shuffle.input.buffer.percent":     "mapreduce.reduce.shuffle.input.buffer.percent",
+	"mapred.job.shuffle.merge.percent":            "mapreduce.reduce.shuffle.merge.percent",
+	"mapred.reduce.parallel.copies":               "mapreduce.reduce.shuffle.parallelcopies",
+	"mapred.inmem.merge.threshold":                "mapreduce.reduce.merge.inmem.threshold",
+	"mapred.job.reduce.input.buffer.percent":      "mapreduce.reduce.input.buffer.percent",
+}
+
+// CurrentKey returns the current name of key, which is key itself if it's not deprecated
+func CurrentKey(key string) string {
+	if current, ok := DeprecatedKeys[key]; ok {
+		return current
+	}
+	return key
+}
+
+// DeprecatedAliases returns the deprecated names of key, sorted
+func DeprecatedAliases(key string) []string {
+	aliases := []string{}
+	for old, current := range DeprecatedKeys {
+		if current == key {
+			aliases = append(aliases, old)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+func isSiteSource(src Source) bool {
+	return src != NoSource && src.SourceType == LocalFile
+}
+
+// AliasSourceGet is like SourceGet, but takes into account deprecated names of key,
+// as hadoop does. A value set in a site file under any name of the key takes precedence
+// over the defaults. alias is the name the value was found under, if it's not key.
+func (c *HadoopConf) AliasSourceGet(key string) (value string, src Source, alias string) {
+	value, src = c.SourceGet(key)
+	if isSiteSource(src) {
+		return value, src, ""
+	}
+	current := CurrentKey(key)
+	for _, name := range append([]string{current}, DeprecatedAliases(current)...) {
+		if name == key {
+			continue
+		}
+		if v, s := c.SourceGet(name); isSiteSource(s) {
+			return v, s, name
+		}
+	}
+	return value, src, ""
+}
+
+// Migration is a rename of a deprecated key in a site file
+type Migration struct {
+	Source Source
+	Old    string
+	New    string
+	Value  string
+	// the current name is already set to a different value, the deprecated key will
+	// be removed and the current value kept
+	Conflict bool
+	conf     ConfSourcer
+}
+
+// Migrations returns the deprecated keys in the site files, and how to rename them
+func (c *HadoopConf) Migrations() []Migration {
+	migrations := []Migration{}
+	for _, site := range c.HadoopSites() {
+		for _, key := range site.Conf.Keys() {
+			current, deprecated := DeprecatedKeys[key]
+			if !deprecated {
+				continue
+			}
+			v, src := site.Conf.SourceGet(key)
+			m := Migration{Source: src, Old: key, New: current, Value: v, conf: site.Conf}
+			if currentVal, currentSrc := c.SourceGet(current); isSiteSource(currentSrc) && currentVal != v {
+				m.Conflict = true
+			}
+			migrations = append(migrations, m)
+		}
+	}
+	return migrations
+}
+
+// Migrate renames deprecated keys in the site files to their current names
+func (c *HadoopConf) Migrate(migrations []Migration) {
+	for _, m := range migrations {
+		if !m.Conflict {
+			m.conf.Set(m.New, m.Value)
+		}
+		m.conf.Delete(m.Old)
+	}
+}
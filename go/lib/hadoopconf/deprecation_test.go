@@ -0,0 +1,73 @@
+package hadoopconf
+
+import (
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func testDeprecationConf(t *testing.T) *HadoopConf {
+	site, err := NewGeneratedConfFromString(Source{"core-site.xml", LocalFile}, coreSite)
+	FailOnErr(err)
+	dflt, err := NewGeneratedConfFromString(Source{"core-default.xml", FileFromJar}, `<configuration>
+  <property><name>fs.defaultFS</name><value>file:///</value></property>
+  <property><name>io.sort.mb</name><value>100</value></property>
+</configuration>`)
+	FailOnErr(err)
+	hdfsSite, err := NewGeneratedConfFromString(Source{"hdfs-site.xml", LocalFile}, `<configuration>
+  <property><name>dfs.name.dir</name><value>/data/nn</value></property>
+  <property><name>dfs.data.dir</name><value>/data/old</value></property>
+  <property><name>dfs.datanode.data.dir</name><value>/data/new</value></property>
+</configuration>`)
+	FailOnErr(err)
+	return FromConf(&ConfWithDefault{site, dflt}, &ConfWithDefault{hdfsSite, nil}, nil, nil)
+}
+
+func TestDeprecatedKeys(t *testing.T) {
+	Terst(t)
+	Is(CurrentKey("fs.default.name"), "fs.defaultFS")
+	Is(CurrentKey("fs.defaultFS"), "fs.defaultFS")
+	Is(DeprecatedAliases("fs.defaultFS"), []string{"fs.default.name"})
+	Is(DeprecatedAliases("no.such.key"), []string{})
+
+	c := testDeprecationConf(t)
+	v, src, alias := c.AliasSourceGet("fs.defaultFS")
+	Is(v, "hdfs://localhost:8020")
+	Is(src.Source, "core-site.xml")
+	Is(alias, "fs.default.name")
+	v, src, alias = c.AliasSourceGet("mapreduce.task.io.sort.mb")
+	Is(v, "")
+	Is(src, NoSource)
+	Is(alias, "")
+	v, src, alias = c.AliasSourceGet("dfs.datanode.data.dir")
+	Is(v, "/data/new")
+	Is(alias, "")
+}
+
+func TestMigrate(t *testing.T) {
+	Terst(t)
+	c := testDeprecationConf(t)
+	migrations := c.Migrations()
+	Is(len(migrations), 3)
+	for _, m := range migrations {
+		switch m.Old {
+		case "fs.default.name":
+			Is(m.New, "fs.defaultFS")
+			Is(m.Conflict, false)
+		case "dfs.name.dir":
+			Is(m.Value, "/data/nn")
+			Is(m.Conflict, false)
+		case "dfs.data.dir":
+			Is(m.Conflict, true)
+		default:
+			Is(m.Old, "an expected migration")
+		}
+	}
+	c.Migrate(migrations)
+	Is(len(c.Migrations()), 0)
+	ValSrc(c.SourceGet("fs.defaultFS")).Is("hdfs://localhost:8020", "core-site.xml")
+	ValSrc(c.SourceGet("fs.default.name")).Empty()
+	ValSrc(c.SourceGet("dfs.namenode.name.dir")).Is("/data/nn", "hdfs-site.xml")
+	ValSrc(c.SourceGet("dfs.datanode.data.dir")).Is("/data/new", "hdfs-site.xml")
+	ValSrc(c.SourceGet("dfs.data.dir")).Empty()
+}
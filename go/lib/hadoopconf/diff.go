@@ -0,0 +1,116 @@
+package hadoopconf
+
+import (
+	"sort"
+)
+
+type DiffKind int
+
+const (
+	Added DiffKind = iota
+	Removed
+	Changed
+)
+
+func (k DiffKind) String() string {
+	switch k {
+	case Added:
+		return "+"
+	case Removed:
+		return "-"
+	}
+	return "~"
+}
+
+// Difference is a key whose effective value differs between two configurations
+type Difference struct {
+	Key  string
+	Kind DiffKind
+	// the values and where they came from, NoSource on the side which lacks the key
+	A, B       string
+	SrcA, SrcB Source
+	// the key is an environment variable from a *-env.sh file
+	Env bool
+}
+
+// DefaultsOnly is true if neither value comes from a site file, that is, the
+// configurations differ only because their defaults do, e.g. different hadoop versions
+func (d *Difference) DefaultsOnly() bool {
+	return !d.Env && !isSiteSource(d.SrcA) && !isSiteSource(d.SrcB)
+}
+
+func difference(key string, a string, srcA Source, b string, srcB Source) (Difference, bool) {
+	d := Difference{Key: key, A: a, B: b, SrcA: srcA, SrcB: srcB}
+	switch {
+	case srcA == NoSource && srcB == NoSource:
+		return d, false
+	case srcA == NoSource:
+		d.Kind = Added
+	case srcB == NoSource:
+		d.Kind = Removed
+	case a != b:
+		d.Kind = Changed
+	default:
+		return d, false
+	}
+	return d, true
+}
+
+func unionKeys(a, b []string) []string {
+	seen := make(map[string]bool)
+	keys := []string{}
+	for _, key := range append(a, b...) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Diff compares the effective values, site over default, of all keys in a and b
+func Diff(a, b *HadoopConf) []Difference {
+	diffs := []Difference{}
+	for _, key := range unionKeys(a.Keys(), b.Keys()) {
+		va, srcA := a.SourceGet(key)
+		vb, srcB := b.SourceGet(key)
+		if d, ok := difference(key, va, srcA, vb, srcB); ok {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+// envSourceGet ignores commented out templates, they are not in effect
+func envSourceGet(envs Envs, name string) (string, Source) {
+	if v := envs.Get(name); v != nil && v.IsSet() {
+		return v.GetVal(), Source{v.Source, LocalFile}
+	}
+	return "", NoSource
+}
+
+// setKeys returns the names of the variables which are set, without the commented out templates
+func setKeys(envs Envs) []string {
+	keys := []string{}
+	for _, name := range envs.Keys() {
+		if v := envs.Get(name); v != nil && v.IsSet() {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// DiffEnv compares the variables of the *-env.sh files of a and b
+func DiffEnv(a, b Envs) []Difference {
+	diffs := []Difference{}
+	for _, name := range unionKeys(setKeys(a), setKeys(b)) {
+		va, srcA := envSourceGet(a, name)
+		vb, srcB := envSourceGet(b, name)
+		if d, ok := difference(name, va, srcA, vb, srcB); ok {
+			d.Env = true
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,84 @@
+package hadoopconf
+
+import (
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func testDiffConf(site, dflt string) *HadoopConf {
+	siteConf, err := NewGeneratedConfFromString(Source{"core-site.xml", LocalFile}, site)
+	FailOnErr(err)
+	dfltConf, err := NewGeneratedConfFromString(Source{"core-default.xml", FileFromJar}, dflt)
+	FailOnErr(err)
+	return FromConf(&ConfWithDefault{siteConf, dfltConf}, &ConfWithDefault{nil, nil}, nil, nil)
+}
+
+func TestDiff(t *testing.T) {
+	Terst(t)
+	a := testDiffConf(`<configuration>
+  <property><name>fs.defaultFS</name><value>hdfs://staging:8020</value></property>
+  <property><name>removed</name><value>x</value></property>
+  <property><name>same</name><value>1</value></property>
+</configuration>`, `<configuration>
+  <property><name>io.file.buffer.size</name><value>4096</value></property>
+  <property><name>same</name><value>1</value></property>
+  <property><name>old.default</name><value>1</value></property>
+</configuration>`)
+	b := testDiffConf(`<configuration>
+  <property><name>fs.defaultFS</name><value>hdfs://prod:8020</value></property>
+  <property><name>added</name><value>y</value></property>
+</configuration>`, `<configuration>
+  <property><name>io.file.buffer.size</name><value>65536</value></property>
+  <property><name>same</name><value>1</value></property>
+</configuration>`)
+	diffs := Diff(a, b)
+	summary := []string{}
+	for _, d := range diffs {
+		s := d.Kind.String() + d.Key
+		if d.DefaultsOnly() {
+			s += " (defaults)"
+		}
+		summary = append(summary, s)
+	}
+	Is(summary, []string{"+added", "~fs.defaultFS", "~io.file.buffer.size (defaults)", "-old.default (defaults)", "-removed"})
+	Is(diffs[1].A, "hdfs://staging:8020")
+	Is(diffs[1].B, "hdfs://prod:8020")
+	Is(diffs[0].SrcA, NoSource)
+	Is(diffs[0].SrcB.Source, "core-site.xml")
+}
+
+func TestDiffEnv(t *testing.T) {
+	Terst(t)
+	a := Envs{&Env{Path: "a/hadoop-env.sh", Vars: []*Var{{Source: "a/hadoop-env.sh", Name: "HADOOP_HEAPSIZE", val: "1000"}}}}
+	b := Envs{&Env{Path: "b/hadoop-env.sh", Vars: []*Var{
+		{Source: "b/hadoop-env.sh", Name: "HADOOP_HEAPSIZE", val: "4000"},
+		{Source: "b/hadoop-env.sh", Name: "JAVA_HOME", val: "/usr/java"},
+	}}}
+	diffs := DiffEnv(a, b)
+	if Is(len(diffs), 2) {
+		Is(diffs[0].Kind, Changed)
+		Is(diffs[0].SrcA.Source, "a/hadoop-env.sh")
+		Is(diffs[0].DefaultsOnly(), false)
+		Is(diffs[1].Kind, Added)
+		Is(diffs[1].Env, true)
+	}
+	Is(len(DiffEnv(a, nil)), 1)
+}
+
+func TestDiffEnvTemplate(t *testing.T) {
+	Terst(t)
+	// "# export HADOOP_HEAPSIZE=2000" is a template, it's not set
+	a := Envs{&Env{Path: "a/hadoop-env.sh", Vars: []*Var{{Source: "a/hadoop-env.sh", Name: "HADOOP_HEAPSIZE", Comment: "2000", commented: true}}}}
+	b := Envs{&Env{Path: "b/hadoop-env.sh"}}
+	Is(len(DiffEnv(a, b)), 0)
+	Is(len(DiffEnv(b, a)), 0)
+
+	b = Envs{&Env{Path: "b/hadoop-env.sh", Vars: []*Var{{Source: "b/hadoop-env.sh", Name: "HADOOP_HEAPSIZE", val: "4000"}}}}
+	diffs := DiffEnv(a, b)
+	if Is(len(diffs), 1) {
+		Is(diffs[0].Kind, Added)
+		Is(diffs[0].SrcA, NoSource)
+		Is(diffs[0].B, "4000")
+	}
+}
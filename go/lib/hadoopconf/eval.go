@@ -0,0 +1,272 @@
+package hadoopconf
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Environment is the environment variables of a shell
+type Environment map[string]string
+
+// OsEnvironment returns the environment of this process
+func OsEnvironment() Environment {
+	env := Environment{}
+	for _, kv := range os.Environ() {
+		if i := strings.IndexByte(kv, '='); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
+
+func (env Environment) copy() Environment {
+	c := Environment{}
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
+}
+
+var (
+	simpleRef = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)`)
+	// ${NAME}, ${NAME:-word}, ${NAME=word} and so on
+	paramRef = regexp.MustCompile(`(?s)^([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-=+])(.*))?$`)
+)
+
+// expand expands word as bash does in an assignment: quotes are removed, and $NAME, ${NAME} and
+// ${NAME:-word} are expanded. ${NAME:=word} assigns NAME too. Command substitutions, and
+// expansions we don't know, are kept as they are.
+func (env Environment) expand(word string) string {
+	var b bytes.Buffer
+	inDouble := false
+	for i := 0; i < len(word); {
+		switch c := word[i]; {
+		case c == '\'' && !inDouble:
+			end := strings.IndexByte(word[i+1:], '\'')
+			if end < 0 {
+				b.WriteString(word[i+1:])
+				return b.String()
+			}
+			b.WriteString(word[i+1 : i+1+end])
+			i += end + 2
+		case c == '"':
+			inDouble = !inDouble
+			i++
+		case c == '\\' && i+1 < len(word):
+			switch next := word[i+1]; {
+			case next == '\n':
+			case !inDouble || strings.IndexByte("$`\"\\", next) >= 0:
+				b.WriteByte(next)
+			default:
+				b.WriteString(word[i : i+2])
+			}
+			i += 2
+		case c == '$':
+			i += env.dollar(word[i:], &b)
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// dollar expands the $ expansion s starts with into b, and returns its length
+func (env Environment) dollar(s string, b *bytes.Buffer) int {
+	if m := simpleRef.FindString(s); m != "" {
+		b.WriteString(env[m[1:]])
+		return len(m)
+	}
+	l := &lexer{src: s}
+	if err := l.dollar(&token{}); err != nil {
+		b.WriteString(s)
+		return len(s)
+	}
+	if l.pos < 2 || s[1] != '{' {
+		// $(...), $1 and such
+		b.WriteString(s[:l.pos])
+		return l.pos
+	}
+	m := paramRef.FindStringSubmatch(s[2 : l.pos-1])
+	if m == nil {
+		b.WriteString(s[:l.pos])
+		return l.pos
+	}
+	name, op := m[1], m[2]
+	value, set := env[name]
+	// with a colon, an empty value is as good as unset
+	if strings.HasPrefix(op, ":") && value == "" {
+		set = false
+	}
+	switch strings.TrimPrefix(op, ":") {
+	case "":
+	case "-":
+		if !set {
+			value = env.expand(m[3])
+		}
+	case "=":
+		if !set {
+			value = env.expand(m[3])
+			env[name] = value
+		}
+	case "+":
+		value = ""
+		if set {
+			value = env.expand(m[3])
+		}
+	}
+	b.WriteString(value)
+	return l.pos
+}
+
+var testCommand = regexp.MustCompile(`(?s)^(?:\[\[?\s(.*)\s\]\]?|test\s(.*))$`)
+
+// eval evaluates the test of a condition, known is false if it's not a test we know
+func (env Environment) eval(test string) (result, known bool) {
+	negate := false
+	if strings.HasPrefix(test, "! ") {
+		negate, test = true, strings.TrimSpace(test[2:])
+	}
+	m := testCommand.FindStringSubmatch(test)
+	if m == nil {
+		return false, false
+	}
+	words := []string{}
+	for _, word := range splitWords(m[1] + m[2]) {
+		// unquoted empty words are removed, so [ -n $EMPTY ] is [ -n ]
+		if value := env.expand(word); value != "" || strings.ContainsAny(word, `'"`) {
+			words = append(words, value)
+		}
+	}
+	switch {
+	case len(words) == 0:
+		result = false
+	case len(words) == 1:
+		result = words[0] != ""
+	case len(words) == 2 && words[0] == "-z":
+		result = words[1] == ""
+	case len(words) == 2 && words[0] == "-n":
+		result = words[1] != ""
+	case len(words) == 3 && (words[1] == "=" || words[1] == "=="):
+		result = words[0] == words[2]
+	case len(words) == 3 && words[1] == "!=":
+		result = words[0] != words[2]
+	default:
+		// files, numbers and such
+		return false, false
+	}
+	return result != negate, true
+}
+
+// Source returns the environment after sourcing the env files named files, such as hadoop-env.sh,
+// in order, as the hadoop scripts do, starting with base. Assignments within loops, functions,
+// or tests we don't know are skipped, and returned.
+func (envs Envs) Source(base Environment, files ...string) (Environment, []*Var) {
+	env := base.copy()
+	skipped := []*Var{}
+	for _, file := range files {
+		e := envs.file(file)
+		if e == nil {
+			continue
+		}
+		// a test runs once, before the assignments it guards change what it tests
+		tests := map[int]bool{}
+	vars:
+		for _, v := range e.Vars {
+			if v.commented || v.unset {
+				continue
+			}
+			for _, c := range v.conds {
+				result, known := tests[c.at], !c.unknown
+				if _, ok := tests[c.at]; !ok && known {
+					result, known = env.eval(c.test)
+					if known {
+						tests[c.at] = result
+					}
+				}
+				if !known {
+					skipped = append(skipped, v)
+					continue vars
+				}
+				if result == c.negate {
+					continue vars
+				}
+			}
+			switch {
+			case v.Func != "":
+				env.call(v)
+			case v.IfUnset && env[v.Name] != "":
+			default:
+				env[v.Name] = env.expand(v.word())
+			}
+		}
+	}
+	return env, skipped
+}
+
+// word is the value of v as it is, or will be, in the file
+func (v *Var) word() string {
+	if v.quote == 0 && !v.modified {
+		return v.val
+	}
+	return quoteValue(v.val, v.quote)
+}
+
+// Effective is how the hadoop scripts start a daemon, given its env files
+type Effective struct {
+	Daemon Daemon
+	// Env is the environment once the env files are sourced
+	Env Environment
+	// JavaHome is the JAVA_HOME the daemon runs with
+	JavaHome string
+	// Opts are the options java gets: the heap size, the common options, such as HADOOP_OPTS, and
+	// those of the daemon
+	Opts *JvmOpts
+	// Skipped are assignments which may take effect, but depend on what we don't evaluate
+	Skipped []*Var
+}
+
+var megabytes = regexp.MustCompile(`^[0-9]+$`)
+
+// parseHeapsize parses the value of a heap size variable in bytes, it's in MB unless it has a
+// unit, which hadoop 3 allows
+func parseHeapsize(s string) (int64, error) {
+	if megabytes.MatchString(s) {
+		s += "m"
+	}
+	return ParseBytes(s)
+}
+
+// Effective evaluates the env files of d, starting with the environment base
+func (envs Envs) Effective(d Daemon, base Environment) *Effective {
+	hadoop3 := envs.Hadoop3()
+	d = envs.Launch(d)
+	env, skipped := envs.Source(base, d.Files...)
+	e := &Effective{Daemon: d, Env: env, JavaHome: env["JAVA_HOME"], Skipped: skipped}
+	if !hadoop3 {
+		heap := "-Xmx1000m"
+		for _, name := range d.Heap {
+			if env[name] != "" {
+				heap = "-Xmx" + env[name] + "m"
+			}
+		}
+		e.Opts = ParseJvmOpts(heap + " " + env[d.Java] + " " + env[d.Opts])
+		return e
+	}
+	// hadoop 3 has no heap size of its own, sizes may have units, and an -Xmx in HADOOP_OPTS wins
+	env.deprecate()
+	java := env[d.Java]
+	for _, name := range d.Heap {
+		if heap := env[name]; heap != "" && !strings.Contains(env[d.Java], "Xmx") {
+			if megabytes.MatchString(heap) {
+				heap += "m"
+			}
+			java = strings.TrimPrefix(env[d.Java]+" -Xmx"+heap, " ")
+		}
+	}
+	e.JavaHome = env["JAVA_HOME"]
+	e.Opts = ParseJvmOpts(java + " " + env[d.Opts])
+	return e
+}
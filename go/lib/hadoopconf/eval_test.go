@@ -0,0 +1,117 @@
+package hadoopconf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestExpandShell(t *testing.T) {
+	Terst(t)
+	env := Environment{"A": "a", "EMPTY": ""}
+	Is(env.expand(`$A-${A}`), "a-a")
+	Is(env.expand(`"-Xmx""$A""m"`), "-Xmxam")
+	Is(env.expand(`'$A' "$A"`), "$A a")
+	Is(env.expand(`${EMPTY:-"/etc/hadoop"}`), "/etc/hadoop")
+	Is(env.expand(`${EMPTY-x}${NONE-x}`), "x")
+	Is(env.expand(`${A:+set}${NONE:+set}`), "set")
+	Is(env.expand(`$(hostname) ${A%a}`), "$(hostname) ${A%a}")
+	Is(env.expand(`${B:=b} $B`), "b b")
+	Is(env["B"], "b")
+}
+
+func TestEvalTest(t *testing.T) {
+	Terst(t)
+	env := Environment{"A": "a"}
+	for test, expected := range map[string]bool{
+		`[ "$A" != "" ]`: true,
+		`[ "$B" = "" ]`:  true,
+		`[ -z "$A" ]`:    false,
+		`[ -n $B ]`:      true,
+		`[ "$B" ]`:       false,
+		`! [ "$A" ]`:     false,
+		`test "$A" == a`: true,
+		`[[ -n "$A" ]]`:  true,
+	} {
+		result, known := env.eval(test)
+		Is(known, true)
+		Is(result, expected)
+	}
+	_, known := env.eval(`[ -d "$A" ]`)
+	Is(known, false)
+	_, known = env.eval(`which java`)
+	Is(known, false)
+}
+
+const evalHadoopEnv = `export JAVA_HOME=${JAVA_HOME:-/usr/java}
+export HADOOP_OPTS="$HADOOP_OPTS -Djava.net.preferIPv4Stack=true"
+export HADOOP_NAMENODE_OPTS="-Dhadoop.security.logger=${HADOOP_SECURITY_LOGGER:-INFO,RFAS} $HADOOP_NAMENODE_OPTS"
+if [ -z "$HADOOP_HEAPSIZE" ]; then
+  HADOOP_HEAPSIZE=2000
+  HADOOP_DATANODE_OPTS=-Xmn100m
+elif [ "$HADOOP_HEAPSIZE" = 1 ]; then
+  HADOOP_DATANODE_OPTS=-Xmn1m
+else
+  HADOOP_DATANODE_OPTS=-Xmn200m
+fi
+[ -n "$SECURE" ] && export HADOOP_DATANODE_OPTS="-Dsecure $HADOOP_DATANODE_OPTS"
+for f in /opt/*.jar; do
+  HADOOP_CLASSPATH=$HADOOP_CLASSPATH:$f
+done
+`
+
+const evalYarnEnv = `YARN_OPTS="$YARN_OPTS -Dyarn.log.dir=${YARN_LOG_DIR:=/var/log/yarn}"
+export YARN_RESOURCEMANAGER_HEAPSIZE=3000
+`
+
+func TestEffective(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hadoop-env.sh": evalHadoopEnv, "yarn-env.sh": evalYarnEnv})
+	defer os.RemoveAll(dir)
+	envs, err := NewEnv(dir)
+	FailOnErr(err)
+
+	namenode, _ := FindDaemon("namenode")
+	e := envs.Effective(namenode, Environment{"HADOOP_NAMENODE_OPTS": "-Dbase"})
+	Is(e.JavaHome, "/usr/java")
+	Is(e.Env["HADOOP_NAMENODE_OPTS"], "-Dhadoop.security.logger=INFO,RFAS -Dbase")
+	Is(e.Opts.String(), "-Xmx2000m -Djava.net.preferIPv4Stack=true -Dhadoop.security.logger=INFO,RFAS -Dbase")
+	if Is(len(e.Skipped), 1) {
+		Is(e.Skipped[0].Name, "HADOOP_CLASSPATH")
+	}
+
+	// the if runs once, though HADOOP_HEAPSIZE is set in its first branch
+	datanode, _ := FindDaemon("datanode")
+	Is(envs.Effective(datanode, Environment{}).Env["HADOOP_DATANODE_OPTS"], "-Xmn100m")
+	Is(envs.Effective(datanode, Environment{"HADOOP_HEAPSIZE": "1"}).Env["HADOOP_DATANODE_OPTS"], "-Xmn1m")
+	e = envs.Effective(datanode, Environment{"HADOOP_HEAPSIZE": "500", "SECURE": "1", "JAVA_HOME": "/opt/java"})
+	Is(e.JavaHome, "/opt/java")
+	Is(e.Env["HADOOP_DATANODE_OPTS"], "-Dsecure -Xmn200m")
+	Is(e.Opts.String(), "-Xmx500m -Djava.net.preferIPv4Stack=true -Dsecure -Xmn200m")
+
+	// yarn-env.sh is sourced after hadoop-env.sh
+	rm, _ := FindDaemon("resourcemanager")
+	e = envs.Effective(rm, Environment{})
+	Is(e.Env["YARN_LOG_DIR"], "/var/log/yarn")
+	Is(e.Opts.String(), "-Xmx3000m -Dyarn.log.dir=/var/log/yarn")
+	Is(e.Env["HADOOP_HEAPSIZE"], "2000")
+
+	// values set but not saved yet count
+	envs.Get("JAVA_HOME").SetVal("/usr/lib/jvm/java 7")
+	Is(envs.Effective(rm, Environment{}).JavaHome, "/usr/lib/jvm/java 7")
+}
+
+func TestEffectiveYarnEnv(t *testing.T) {
+	Terst(t)
+	envs, err := NewEnv(filepath.Join(tempDir, hadoop2))
+	FailOnErr(err)
+	nm, _ := FindDaemon("nodemanager")
+	e := envs.Effective(nm, Environment{"JAVA_HOME": "/usr/java", "YARN_HEAPSIZE": "1500"})
+	Is(e.JavaHome, "/usr/java")
+	Is(e.Env["JAVA"], "/usr/java/bin/java")
+	Is(e.Env["JAVA_HEAP_MAX"], "-Xmx1500m")
+	xmx, _ := e.Opts.Get("-Xmx")
+	Is(xmx.Value, "1500m")
+}
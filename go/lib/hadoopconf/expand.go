@@ -0,0 +1,148 @@
+package hadoopconf
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// MaxSubstitutionDepth is the maximal nesting of ${...} references,
+// hadoop's Configuration gives up after 20 levels as well.
+const MaxSubstitutionDepth = 20
+
+// SourceGetter is anything that can look up a key and tell where its value came from,
+// such as *HadoopConf, *ConfWithDefault or *FileConfiguration
+type SourceGetter interface {
+	SourceGet(key string) (value string, src Source)
+}
+
+// same pattern hadoop's Configuration uses to find variables
+var varPattern = regexp.MustCompile(`\$\{[^\}\$ ]+\}`)
+
+// Expander resolves ${...} references in configuration values the way
+// org.apache.hadoop.conf.Configuration does. A reference is looked up
+// in Props (java system properties, such as user.name), and then in Conf.
+// References of the form ${env.FOO}, ${env.FOO:-default} and ${env.FOO-default}
+// are taken from the environment.
+// References which cannot be resolved are left as is.
+type Expander struct {
+	Conf      SourceGetter
+	Props     map[string]string
+	LookupEnv func(key string) (string, bool)
+}
+
+// NewExpander returns an expander over conf, with the system properties
+// a JVM started by the current user would have.
+func NewExpander(conf SourceGetter) *Expander {
+	return &Expander{conf, SystemProperties(), os.LookupEnv}
+}
+
+// SystemProperties returns the java system properties hadoop commonly refers to
+// in its configuration files, as they would be in a JVM run by the current user
+func SystemProperties() map[string]string {
+	props := map[string]string{
+		"java.io.tmpdir": os.TempDir(),
+		"file.separator": string(filepath.Separator),
+		"path.separator": string(filepath.ListSeparator),
+		"line.separator": "\n",
+		"os.name":        runtime.GOOS,
+	}
+	if u, err := user.Current(); err == nil {
+		props["user.name"] = u.Username
+		props["user.home"] = u.HomeDir
+	}
+	if wd, err := os.Getwd(); err == nil {
+		props["user.dir"] = wd
+	}
+	return props
+}
+
+// Expand replaces all ${...} references in value
+func (e *Expander) Expand(value string) (string, error) {
+	return e.expand(value, nil)
+}
+
+// SourceGet returns the expanded value of key, and the source of its raw value
+func (e *Expander) SourceGet(key string) (value string, src Source, err error) {
+	raw, src := e.Conf.SourceGet(key)
+	value, err = e.expand(raw, []string{key})
+	return value, src, err
+}
+
+func (e *Expander) expand(value string, stack []string) (string, error) {
+	if len(stack) > MaxSubstitutionDepth {
+		return value, errors.New("variable substitution depth too large: " +
+			strconv.Itoa(MaxSubstitutionDepth) + " " + strings.Join(stack, " -> "))
+	}
+	var err error
+	expanded := varPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		name := ref[2 : len(ref)-1]
+		for _, seen := range stack {
+			if seen == name {
+				err = errors.New("variable substitution cycle: " + strings.Join(append(stack, name), " -> "))
+				return ref
+			}
+		}
+		v, ok := e.lookup(name)
+		if !ok {
+			return ref
+		}
+		var r string
+		r, err = e.expand(v, append(stack, name))
+		return r
+	})
+	return expanded, err
+}
+
+func (e *Expander) lookup(name string) (string, bool) {
+	if strings.HasPrefix(name, "env.") {
+		return e.lookupEnv(name[len("env."):])
+	}
+	if v, ok := e.Props[name]; ok {
+		return v, true
+	}
+	if e.Conf == nil {
+		return "", false
+	}
+	v, src := e.Conf.SourceGet(name)
+	return v, src != NoSource
+}
+
+// lookupEnv supports the ${env.FOO:-default} and ${env.FOO-default} forms hadoop 3 accepts
+func (e *Expander) lookupEnv(name string) (string, bool) {
+	lookup := e.LookupEnv
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	if i := strings.Index(name, ":-"); i >= 0 {
+		if v, _ := lookup(name[:i]); v != "" {
+			return v, true
+		}
+		return name[i+2:], true
+	}
+	if i := strings.Index(name, "-"); i >= 0 {
+		if v, ok := lookup(name[:i]); ok {
+			return v, true
+		}
+		return name[i+1:], true
+	}
+	return lookup(name)
+}
+
+// Expand resolves ${...} references in value against all of hadoop's configuration files
+func (c *HadoopConf) Expand(value string) (string, error) {
+	return NewExpander(c).Expand(value)
+}
+
+// ResolvedSourceGet is like SourceGet, but with all ${...} references in the value expanded
+func (c *HadoopConf) ResolvedSourceGet(key string) (value string, src Source, err error) {
+	return NewExpander(c).SourceGet(key)
+}
@@ -0,0 +1,74 @@
+package hadoopconf
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func testExpander(t *testing.T, site string) *Expander {
+	coreSite, err := NewGeneratedConfFromString(Source{"coreSite", Generated}, site)
+	Is(err, nil)
+	coreDefault, err := NewGeneratedConfFromString(Source{"coreDefault", Generated}, `<configuration>
+  <property><name>hadoop.tmp.dir</name><value>/tmp/hadoop-${user.name}</value></property>
+  <property><name>dfs.namenode.name.dir</name><value>file://${hadoop.tmp.dir}/dfs/name</value></property>
+</configuration>`)
+	Is(err, nil)
+	env := map[string]string{"FOO": "foo", "EMPTY": ""}
+	return &Expander{&ConfWithDefault{coreSite, coreDefault},
+		map[string]string{"user.name": "hdfs"},
+		func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		}}
+}
+
+func TestExpand(t *testing.T) {
+	Terst(t)
+	e := testExpander(t, `<configuration>
+  <property><name>my.dir</name><value>${env.FOO}/${env.BAR:-bar}/${env.EMPTY:-empty}/${env.EMPTY-unset}</value></property>
+  <property><name>unknown</name><value>${no.such.key}/x</value></property>
+</configuration>`)
+	v, src, err := e.SourceGet("dfs.namenode.name.dir")
+	Is(err, nil)
+	Is(v, "file:///tmp/hadoop-hdfs/dfs/name")
+	Is(src.Source, "coreDefault")
+	v, _, err = e.SourceGet("my.dir")
+	Is(err, nil)
+	Is(v, "foo/bar/empty/")
+	v, _, err = e.SourceGet("unknown")
+	Is(err, nil)
+	Is(v, "${no.such.key}/x")
+	v, err = e.Expand("${user.name}@${hadoop.tmp.dir}")
+	Is(err, nil)
+	Is(v, "hdfs@/tmp/hadoop-hdfs")
+}
+
+func TestExpandCycles(t *testing.T) {
+	Terst(t)
+	e := testExpander(t, `<configuration>
+  <property><name>a</name><value>${b}</value></property>
+  <property><name>b</name><value>x${a}</value></property>
+  <property><name>self</name><value>${self}</value></property>
+</configuration>`)
+	_, _, err := e.SourceGet("a")
+	if IsNot(err, nil) {
+		Is(err.Error(), "variable substitution cycle: a -> b -> a")
+	}
+	_, _, err = e.SourceGet("self")
+	IsNot(err, nil)
+
+	deep := []string{"<configuration>"}
+	for i := 0; i < MaxSubstitutionDepth+5; i++ {
+		deep = append(deep, "<property><name>k"+string(rune('a'+i))+"</name><value>${k"+string(rune('a'+i+1))+"}</value></property>")
+	}
+	deep = append(deep, "</configuration>")
+	e = testExpander(t, strings.Join(deep, "\n"))
+	_, _, err = e.SourceGet("ka")
+	if IsNot(err, nil) {
+		Is(strings.HasPrefix(err.Error(), "variable substitution depth too large: 20"), true)
+	}
+	_, _, err = e.SourceGet("k" + string(rune('a'+10)))
+	Is(err, nil)
+}
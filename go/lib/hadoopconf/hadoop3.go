@@ -0,0 +1,238 @@
+package hadoopconf
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// DeprecatedEnvVars maps env variables hadoop 3 deprecated to their current names. Hadoop 3's
+// scripts still read the deprecated names, and prefer their values, but warn about them.
+var DeprecatedEnvVars = map[string]string{
+	// hdfs
+	"HADOOP_NAMENODE_OPTS":          "HDFS_NAMENODE_OPTS",
+	"HADOOP_SECONDARYNAMENODE_OPTS": "HDFS_SECONDARYNAMENODE_OPTS",
+	"HADOOP_DATANODE_OPTS":          "HDFS_DATANODE_OPTS",
+	"HADOOP_JOURNALNODE_OPTS":       "HDFS_JOURNALNODE_OPTS",
+	"HADOOP_ZKFC_OPTS":              "HDFS_ZKFC_OPTS",
+	"HADOOP_BALANCER_OPTS":          "HDFS_BALANCER_OPTS",
+	"HADOOP_MOVER_OPTS":             "HDFS_MOVER_OPTS",
+	"HADOOP_NFS3_OPTS":              "HDFS_NFS3_OPTS",
+	"HADOOP_PORTMAP_OPTS":           "HDFS_PORTMAP_OPTS",
+	"HADOOP_DN_SECURE_EXTRA_OPTS":   "HDFS_DATANODE_SECURE_EXTRA_OPTS",
+	"HADOOP_NFS3_SECURE_EXTRA_OPTS": "HDFS_NFS3_SECURE_EXTRA_OPTS",
+	"HADOOP_SECURE_DN_USER":         "HDFS_DATANODE_SECURE_USER",
+	"HADOOP_PRIVILEGED_NFS_USER":    "HDFS_NFS3_SECURE_USER",
+	"HADOOP_SECURE_DN_PID_DIR":      "HADOOP_SECURE_PID_DIR",
+	"HADOOP_SECURE_DN_LOG_DIR":      "HADOOP_SECURE_LOG_DIR",
+	// common
+	"HADOOP_HEAPSIZE":    "HADOOP_HEAPSIZE_MAX",
+	"HADOOP_PREFIX":      "HADOOP_HOME",
+	"HADOOP_SLAVES":      "HADOOP_WORKERS",
+	"HADOOP_SLAVE_NAMES": "HADOOP_WORKER_NAMES",
+	"HADOOP_SLAVE_SLEEP": "HADOOP_WORKER_SLEEP",
+	// yarn
+	"YARN_CONF_DIR":     "HADOOP_CONF_DIR",
+	"YARN_LOG_DIR":      "HADOOP_LOG_DIR",
+	"YARN_LOGFILE":      "HADOOP_LOGFILE",
+	"YARN_NICENESS":     "HADOOP_NICENESS",
+	"YARN_STOP_TIMEOUT": "HADOOP_STOP_TIMEOUT",
+	"YARN_PID_DIR":      "HADOOP_PID_DIR",
+	"YARN_ROOT_LOGGER":  "HADOOP_ROOT_LOGGER",
+	"YARN_IDENT_STRING": "HADOOP_IDENT_STRING",
+	"YARN_OPTS":         "HADOOP_OPTS",
+	"YARN_SLAVES":       "HADOOP_WORKERS",
+	// mapreduce
+	"HADOOP_JOB_HISTORYSERVER_OPTS": "MAPRED_HISTORYSERVER_OPTS",
+	"HADOOP_MAPRED_LOG_DIR":         "HADOOP_LOG_DIR",
+	"HADOOP_MAPRED_LOGFILE":         "HADOOP_LOGFILE",
+	"HADOOP_MAPRED_NICENESS":        "HADOOP_NICENESS",
+	"HADOOP_MAPRED_STOP_TIMEOUT":    "HADOOP_STOP_TIMEOUT",
+	"HADOOP_MAPRED_PID_DIR":         "HADOOP_PID_DIR",
+	"HADOOP_MAPRED_ROOT_LOGGER":     "HADOOP_ROOT_LOGGER",
+	"HADOOP_MAPRED_IDENT_STRING":    "HADOOP_IDENT_STRING",
+}
+
+// CurrentEnvVar returns the hadoop 3 name of name, which is name itself if it's not deprecated
+func CurrentEnvVar(name string) string {
+	if current, ok := DeprecatedEnvVars[name]; ok {
+		return current
+	}
+	return name
+}
+
+// hadoop3Functions are the functions of hadoop 3's scripts env files call to add to a variable,
+// and the variable each adds to. hadoop_add_param adds to the variable of its first argument.
+var hadoop3Functions = map[string]string{
+	"hadoop_add_param":       "",
+	"hadoop_add_classpath":   "CLASSPATH",
+	"hadoop_add_javalibpath": "JAVA_LIBRARY_PATH",
+	"hadoop_add_ldlibpath":   "LD_LIBRARY_PATH",
+}
+
+// variables only hadoop 3's env files have
+var hadoop3Var = regexp.MustCompile(`^(HDFS_[A-Z_]+_OPTS|HADOOP_HEAPSIZE_M(AX|IN)|HADOOP_WORKERS|HADOOP_OS_TYPE|MAPRED_HISTORYSERVER_OPTS)$`)
+
+// Hadoop3 tells if envs are hadoop 3's env files: they call hadoop 3's functions, set hadoop 3's
+// variables, or a workers file, which replaced slaves, is next to them
+func (envs Envs) Hadoop3() bool {
+	for _, env := range envs {
+		for _, v := range env.Vars {
+			if v.Func != "" || hadoop3Var.MatchString(v.Name) {
+				return true
+			}
+		}
+		if _, err := os.Stat(filepath.Join(filepath.Dir(env.Path), "workers")); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Launch returns d as the scripts of envs' hadoop version launch it. Hadoop 3 reads the
+// hadoop 3 names of its variables, and adds the options of all daemons to HADOOP_OPTS.
+func (envs Envs) Launch(d Daemon) Daemon {
+	if !envs.Hadoop3() {
+		return d
+	}
+	d.Opts = CurrentEnvVar(d.Opts)
+	d.Java = "HADOOP_OPTS"
+	heap := []string{"HADOOP_HEAPSIZE_MAX"}
+	if len(d.Heap) > 1 {
+		// a deprecated heap size of the daemon, hadoop 3 prefers it
+		heap = append(heap, d.Heap[1:]...)
+	}
+	d.Heap = heap
+	return d
+}
+
+// Calls returns the calls of hadoop 3's functions which add to name
+func (envs Envs) Calls(name string) []*Var {
+	calls := []*Var{}
+	for _, env := range envs {
+		for _, v := range env.Vars {
+			if v.Name == name && v.Func != "" && !v.unset {
+				calls = append(calls, v)
+			}
+		}
+	}
+	return calls
+}
+
+// AddCall adds a call of the hadoop 3 function fn, which adds to name, to the end of env
+func (env *Env) AddCall(fn, name, param string) *Var {
+	v := env.Add(name)
+	v.Func, v.param, v.quote = fn, param, '"'
+	return v
+}
+
+// callText is the call of v's function, which adds the value of v to the variable
+func (v *Var) callText() string {
+	args := []string{v.Func}
+	if hadoop3Functions[v.Func] == "" {
+		args = append(args, v.Name, quoteValue(v.param, 0))
+	}
+	args = append(args, quoteValue(v.GetVal(), v.quote))
+	if hadoop3Functions[v.Func] != "" && v.param != "" {
+		args = append(args, v.param)
+	}
+	return strings.Join(args, " ")
+}
+
+// paramCheck is what hadoop_add_param looks for in a variable, to tell if an option is already there,
+// such as Xmx for -Xmx4g, or hadoop.log.dir for -Dhadoop.log.dir=/var/log
+func paramCheck(opt JvmOption) string {
+	for _, prefix := range []string{"-XX:", "-D", "-"} {
+		if strings.HasPrefix(opt.Key, prefix) {
+			return strings.TrimPrefix(opt.Key, prefix)
+		}
+	}
+	return opt.Key
+}
+
+// AddParams adds values to name as hadoop 3 env files do: hadoop_add_param for each java option
+// of a *_OPTS variable, and hadoop_add_classpath for each path of HADOOP_CLASSPATH, before the
+// classpath if before is true. A call which adds the same option is changed, rather than added.
+// It returns the calls, nil if name is neither.
+func (envs Envs) AddParams(name string, values []string, before bool) []*Var {
+	env := envs.FileFor(name)
+	if env == nil {
+		return nil
+	}
+	calls := []*Var{}
+	switch {
+	case strings.HasSuffix(name, "_OPTS"):
+		for _, opt := range ParseJvmOpts(strings.Join(values, " ")).Options {
+			check := paramCheck(opt)
+			var v *Var
+			for _, call := range envs.Calls(name) {
+				if call.Func == "hadoop_add_param" && call.param == check {
+					v = call
+				}
+			}
+			if v == nil {
+				v = env.AddCall("hadoop_add_param", name, check)
+			}
+			v.SetVal(opt.Text)
+			calls = append(calls, v)
+		}
+	case name == "HADOOP_CLASSPATH":
+		where := "after"
+		if before {
+			where = "before"
+		}
+		for _, value := range values {
+			for _, path := range filepath.SplitList(value) {
+				v := env.AddCall("hadoop_add_classpath", "CLASSPATH", where)
+				v.SetVal(path)
+				calls = append(calls, v)
+			}
+		}
+	default:
+		return nil
+	}
+	return calls
+}
+
+// call runs the hadoop 3 function call v, which adds to a variable
+func (env Environment) call(v *Var) {
+	value := env.expand(v.word())
+	if v.Func == "hadoop_add_param" {
+		check := env.expand(v.param)
+		// hadoop_add_param uses [[ =~ ]], though checks are seldom more than words
+		if re, err := regexp.Compile(check); err == nil && re.MatchString(env[v.Name]) {
+			return
+		}
+		env[v.Name] = strings.TrimPrefix(env[v.Name]+" "+value, " ")
+		return
+	}
+	list := env[v.Name]
+	if strings.Contains(":"+list+":", ":"+value+":") {
+		return
+	}
+	switch {
+	case list == "":
+		list = value
+	case v.param == "before":
+		list = value + ":" + list
+	default:
+		list = list + ":" + value
+	}
+	env[v.Name] = list
+}
+
+// deprecate sets the hadoop 3 names of deprecated variables, as hadoop 3's scripts do
+func (env Environment) deprecate() {
+	olds := []string{}
+	for old := range DeprecatedEnvVars {
+		olds = append(olds, old)
+	}
+	sort.Strings(olds)
+	for _, old := range olds {
+		if env[old] != "" {
+			env[DeprecatedEnvVars[old]] = env[old]
+		}
+	}
+}
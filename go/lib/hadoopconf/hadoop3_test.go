@@ -0,0 +1,89 @@
+package hadoopconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+const hadoop3Env = `export JAVA_HOME=/usr/java
+export HADOOP_OS_TYPE=${HADOOP_OS_TYPE:-$(uname -s)}
+export HADOOP_HEAPSIZE_MAX=4096
+export HDFS_NAMENODE_OPTS="-Dhadoop.security.logger=INFO,RFAS"
+export HADOOP_DATANODE_OPTS="-Xmn100m"
+hadoop_add_param HADOOP_OPTS java.net.preferIPv4Stack "-Djava.net.preferIPv4Stack=true"
+hadoop_add_param HADOOP_OPTS java.net.preferIPv4Stack "-Djava.net.preferIPv4Stack=false"
+hadoop_add_classpath /opt/extra.jar
+`
+
+func TestHadoop3Env(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hadoop-env.sh": hadoop3Env})
+	defer os.RemoveAll(dir)
+	envs, err := NewEnv(dir)
+	FailOnErr(err)
+	Is(envs.Hadoop3(), true)
+	v := envs.Get("HADOOP_OPTS")
+	Is(v.Func, "hadoop_add_param")
+	Is(v.GetVal(), "-Djava.net.preferIPv4Stack=false")
+	Is(len(envs.Calls("HADOOP_OPTS")), 2)
+	v = envs.Get("CLASSPATH")
+	Is(v.Func, "hadoop_add_classpath")
+	Is(v.GetVal(), "/opt/extra.jar")
+
+	hadoop2Envs, err := NewEnv(filepath.Join(tempDir, hadoop2))
+	FailOnErr(err)
+	Is(hadoop2Envs.Hadoop3(), false)
+
+	// the second hadoop_add_param finds java.net.preferIPv4Stack, and adds nothing
+	namenode, _ := FindDaemon("namenode")
+	e := envs.Effective(namenode, Environment{})
+	Is(e.Daemon.Opts, "HDFS_NAMENODE_OPTS")
+	Is(e.Env["CLASSPATH"], "/opt/extra.jar")
+	Is(e.Opts.String(), "-Djava.net.preferIPv4Stack=true -Xmx4096m -Dhadoop.security.logger=INFO,RFAS")
+	// the deprecated name wins
+	datanode, _ := FindDaemon("datanode")
+	e = envs.Effective(datanode, Environment{"HDFS_DATANODE_OPTS": "-Xmn1m"})
+	Is(e.Env["HDFS_DATANODE_OPTS"], "-Xmn100m")
+	Is(e.Opts.String(), "-Djava.net.preferIPv4Stack=true -Xmx4096m -Xmn100m")
+	e = envs.Effective(datanode, Environment{"HADOOP_OPTS": "-Xmx1g"})
+	Is(e.Opts.String(), "-Xmx1g -Djava.net.preferIPv4Stack=true -Xmn100m")
+
+	Is(CurrentEnvVar("HADOOP_NAMENODE_OPTS"), "HDFS_NAMENODE_OPTS")
+	Is(CurrentEnvVar("HDFS_NAMENODE_OPTS"), "HDFS_NAMENODE_OPTS")
+	Is(envs.Launch(namenode).Opts, "HDFS_NAMENODE_OPTS")
+	Is(hadoop2Envs.Launch(namenode).Opts, "HADOOP_NAMENODE_OPTS")
+}
+
+func TestHadoop3AddParams(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hadoop-env.sh": hadoop3Env})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hadoop-env.sh")
+	envs, err := NewEnv(dir)
+	FailOnErr(err)
+	Is(len(envs.AddParams("HDFS_NAMENODE_OPTS", []string{"-Xmx8g", "-XX:+UseG1GC"}, true)), 2)
+	Is(len(envs.AddParams("HADOOP_CLASSPATH", []string{"/a.jar:/b.jar"}, true)), 2)
+	Is(envs.AddParams("JAVA_HOME", []string{"/opt/java"}, true), ([]*Var)(nil))
+	FailOnErr(envs.Save(false))
+	expected := hadoop3Env + `hadoop_add_param HDFS_NAMENODE_OPTS Xmx "-Xmx8g"
+hadoop_add_param HDFS_NAMENODE_OPTS UseG1GC "-XX:+UseG1GC"
+hadoop_add_classpath "/a.jar" before
+hadoop_add_classpath "/b.jar" before
+`
+	Is(readFile(path), expected)
+
+	// an option which is added already is changed
+	calls := envs.AddParams("HDFS_NAMENODE_OPTS", []string{"-Xmx2g"}, false)
+	if Is(len(calls), 1) {
+		Is(calls[0].IsSet(), true)
+	}
+	FailOnErr(envs.Save(false))
+	Is(readFile(path), strings.Replace(expected, `"-Xmx8g"`, `"-Xmx2g"`, 1))
+	namenode, _ := FindDaemon("namenode")
+	xmx, _ := envs.Effective(namenode, Environment{}).Opts.Get("-Xmx")
+	Is(xmx.Text, "-Xmx2g")
+}
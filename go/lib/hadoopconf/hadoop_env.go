@@ -1,31 +1,72 @@
 package hadoopconf
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// an evironment variable is a line of the form
-// export FOO_OPT="-a -b -c" in a bash environment
-// file.
+// Env is a bash file which sets environment variables, such as
+// export FOO_OPT="-a -b -c"
 type Env struct {
 	Path string
 	Vars []*Var
+	// the file as it was read, to tell if someone else changed it before we save
+	stamp *fileStamp
+	// why the file, as it was saved, could not be read back. It cannot be saved again, as the
+	// positions of the variables are not those in the file.
+	unread error
 }
 
+// Var is an assignment of a variable in an env file
 type Var struct {
 	modified bool
-	line     int
-	Comment  string
-	Source   string
-	Name     string
-	val      string
+	// zero based line of the assignment
+	line    int
+	Comment string
+	Source  string
+	Name    string
+	val     string
+	// Exported is true if the variable is exported, by the assignment or by a later export
+	Exported bool
+	// Conditional is true for assignments within if, case, a loop or a function, or after
+	// && or ||, which may not take effect
+	Conditional bool
+	// IfUnset is true for ${NAME:=value}, which assigns only if NAME is unset or empty
+	IfUnset bool
+	// the conditions of a Conditional assignment
+	conds []condition
+	// Func is the hadoop 3 function which adds the value to the variable, such as hadoop_add_param,
+	// empty for assignments
+	Func string
+	// the check of hadoop_add_param, or before or after of hadoop_add_classpath
+	param string
+	// the assignment is commented out, as in "# export NAME=value"
+	commented bool
+	// the variable is new, it's added to the end of the file
+	added bool
+	// the assignment is to be commented out
+	unset bool
+	// where the value, and the whole assignment, are in the file
+	value, stmt span
+	// the quote character of the value in the file, 0 if it isn't quoted
+	quote byte
+}
+
+// IsSet is false for commented out variables, and new ones which were not saved yet
+func (v *Var) IsSet() bool {
+	return !v.commented && !v.added
+}
+
+// Line is the line of the assignment in its file, starting with 1
+func (v *Var) Line() int {
+	return v.line + 1
 }
 
 func (v *Var) GetVal() string {
@@ -81,13 +122,17 @@ func (v *Var) Append(tok string) {
 
 type Envs []*Env
 
+// Get returns the assignment of name, preferring actual assignments in any file over commented out ones
 func (envs Envs) Get(name string) *Var {
+	var commented *Var
 	for _, env := range envs {
-		if r := env.Get(name); r != nil {
+		if r := env.Get(name); r != nil && !r.commented {
 			return r
+		} else if r != nil && commented == nil {
+			commented = r
 		}
 	}
-	return nil
+	return commented
 }
 
 func (envs Envs) Keys() []string {
@@ -95,18 +140,101 @@ func (envs Envs) Keys() []string {
 	for _, env := range envs {
 		keys = append(keys, env.Keys()...)
 	}
-	return keys
+	return unionKeys(keys, nil)
 }
 
+// Save saves the modified env files, all or nothing. Their backups share the same timestamp.
 func (envs Envs) Save(backup bool) error {
+	suffix := ""
+	if backup {
+		suffix = backupSuffix(time.Now())
+	}
+	tx := newTransaction(suffix)
 	for _, env := range envs {
-		if err := env.Save(backup); err != nil {
+		if err := env.stage(tx); err != nil {
+			tx.Abort()
 			return err
 		}
 	}
+	return tx.Commit()
+}
+
+// envFilePrefixes are the prefixes of variables which belong to an env file other than hadoop-env.sh
+var envFilePrefixes = []struct{ prefix, file string }{
+	{"YARN_", "yarn-env.sh"},
+	{"HADOOP_YARN_", "yarn-env.sh"},
+	{"MAPRED_", "mapred-env.sh"},
+	{"HADOOP_MAPRED_", "mapred-env.sh"},
+	{"HADOOP_JOB_HISTORYSERVER_", "mapred-env.sh"},
+	{"HTTPFS_", "httpfs-env.sh"},
+	{"KMS_", "kms-env.sh"},
+}
+
+// FileFor returns the env file a new variable belongs to, by its prefix. Other variables, or
+// those whose file is missing, belong to hadoop-env.sh, or to the first file if it's missing too.
+func (envs Envs) FileFor(name string) *Env {
+	if len(envs) == 0 {
+		return nil
+	}
+	file := "hadoop-env.sh"
+	for _, p := range envFilePrefixes {
+		if strings.HasPrefix(name, p.prefix) && envs.file(p.file) != nil {
+			file = p.file
+		}
+	}
+	if env := envs.file(file); env != nil {
+		return env
+	}
+	return envs[0]
+}
+
+func (envs Envs) file(base string) *Env {
+	for _, env := range envs {
+		if filepath.Base(env.Path) == base {
+			return env
+		}
+	}
+	return nil
+}
+
+// GetOrAdd returns the variable, possibly a commented out one which is uncommented once set.
+// If there's none, it's added to the file FileFor chooses.
+func (envs Envs) GetOrAdd(name string) *Var {
+	if v := envs.Get(name); v != nil {
+		return v
+	}
+	if env := envs.FileFor(name); env != nil {
+		return env.Add(name)
+	}
 	return nil
 }
 
+// Unset comments out the assignments of name in all files, and returns them. If any can't
+// be commented out, none is.
+func (envs Envs) Unset(name string) ([]*Var, error) {
+	unset := []*Var{}
+	for _, env := range envs {
+		vars, err := env.assignments(name)
+		if err != nil {
+			return nil, err
+		}
+		unset = append(unset, vars...)
+	}
+	for _, v := range unset {
+		v.unset, v.modified = true, true
+	}
+	return unset, nil
+}
+
+// Files returns the paths of the env files
+func (envs Envs) Files() []string {
+	files := []string{}
+	for _, env := range envs {
+		files = append(files, env.Path)
+	}
+	return files
+}
+
 /*snprintf(systembuf, sizeof(systembuf), "echo 'attach %d\nbt\nquit' | gdb -quiet _test_main.out ", getpid());*/
 
 func NewEnv(path string) (Envs, error) {
@@ -132,57 +260,128 @@ func NewEnv(path string) (Envs, error) {
 	return envs, nil
 }
 
-var exportLine = regexp.MustCompile(`^\s*(#?)\s*export\s+([A-Z0-9_]+)=(.*)$`)
-
-// parseExport is a poor man's parser of bash export line.
-// If you don't abuse bash too much - it should work.
+// parseExport parses a single line of an env file, lineno is its zero based line
 func parseExport(filename string, lineno int, line string) *Var {
-	if matches := exportLine.FindStringSubmatch(line); matches != nil {
-		s := matches[3]
-		if strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
-			s = s[1 : len(s)-1]
-		}
-		comment := ""
-		if matches[1] == "#" {
-			comment = s
-			s = ""
-		}
-		return &Var{false, lineno, comment, filename, matches[2], s}
+	vars, err := parseShell(filename, line, 0)
+	if err != nil || len(vars) == 0 {
+		return nil
 	}
-	return nil
+	vars[0].line += lineno
+	return vars[0]
 }
 
 func NewEnvFromFile(path string) (*Env, error) {
-	f, err := os.Open(path)
-	if err != nil {
+	if _, err := os.Stat(path); err != nil {
 		return nil, err
 	}
-	env := Env{path, nil}
-	scanner := bufio.NewScanner(f)
-	for i := 0; scanner.Scan(); i++ {
-		if v := parseExport(path, i, scanner.Text()); v != nil {
-			env.Vars = append(env.Vars, v)
-		}
+	content, stamp, err := readStamped(path)
+	if err != nil {
+		return nil, err
 	}
-	if err := scanner.Err(); err != nil {
+	env := &Env{Path: path, stamp: stamp}
+	if err := env.parse(content); err != nil {
 		return nil, err
 	}
-	return &env, nil
+	return env, nil
+}
+
+// parse reads the variables of env from content
+func (env *Env) parse(content []byte) error {
+	vars, err := parseShell(env.Path, string(content), 0)
+	if err != nil {
+		return err
+	}
+	env.Vars = vars
+	return nil
 }
 
+// Get returns the assignment of name which is in effect after the file runs: the last one
+// which is unconditional, else the last conditional one, else the last call of a hadoop 3
+// function which adds to it, else the first commented out one
 func (env *Env) Get(name string) *Var {
+	var last, conditional, call, commented *Var
 	for _, v := range env.Vars {
-		if v.Name == name {
-			return v
+		switch {
+		case v.Name != name:
+		case v.commented:
+			if commented == nil {
+				commented = v
+			}
+		case v.Func != "":
+			call = v
+		case v.Conditional:
+			conditional = v
+		default:
+			last = v
 		}
 	}
-	return nil
+	switch {
+	case last != nil:
+		return last
+	case conditional != nil:
+		return conditional
+	case call != nil:
+		return call
+	}
+	return commented
+}
+
+// Add adds an exported variable to the end of env, it's written once set
+func (env *Env) Add(name string) *Var {
+	end := 0
+	if env.stamp != nil {
+		end = len(env.stamp.content)
+	}
+	v := &Var{Name: name, Source: env.Path, Exported: true, added: true, stmt: span{end, end}, value: span{end, end}}
+	env.Vars = append(env.Vars, v)
+	return v
+}
+
+var (
+	beforeAlone = regexp.MustCompile(`^[ \t]*(export[ \t]+)?$`)
+	afterAlone  = regexp.MustCompile(`^[ \t]*(#.*)?$`)
+)
+
+// Unset comments out the assignments of name, and returns them. An assignment which shares its
+// line with other commands cannot be commented out without them, so it's an error.
+func (env *Env) Unset(name string) ([]*Var, error) {
+	return Envs{env}.Unset(name)
+}
+
+// assignments returns the assignments of name, it's an error if any can't be commented out
+func (env *Env) assignments(name string) ([]*Var, error) {
+	unset := []*Var{}
+	for _, v := range env.Vars {
+		if v.Name != name || !v.IsSet() {
+			continue
+		}
+		start, end := v.lines(env.stamp.content)
+		if !beforeAlone.Match(env.stamp.content[start:v.stmt.start]) || !afterAlone.Match(env.stamp.content[v.stmt.end:end]) {
+			return nil, errors.New("cannot comment out " + name + " in " + env.Path + ":" + strconv.Itoa(v.line+1) + ", other commands share its line")
+		}
+		unset = append(unset, v)
+	}
+	return unset, nil
+}
+
+// lines returns the start of the first line, and the end of the last line, of the assignment
+func (v *Var) lines(content []byte) (start, end int) {
+	start = bytes.LastIndexByte(content[:v.stmt.start], '\n') + 1
+	end = len(content)
+	if i := bytes.IndexByte(content[v.stmt.end:], '\n'); i >= 0 {
+		end = v.stmt.end + i
+	}
+	return start, end
 }
 
 func (env *Env) Keys() []string {
 	keys := []string{}
+	seen := make(map[string]bool)
 	for _, v := range env.Vars {
-		keys = append(keys, v.Name)
+		if !seen[v.Name] {
+			seen[v.Name] = true
+			keys = append(keys, v.Name)
+		}
 	}
 	return keys
 }
@@ -195,37 +394,95 @@ func (env *Env) GetValue(name string) string {
 	return v.Name
 }
 
+func (env *Env) modified() bool {
+	for _, v := range env.Vars {
+		if v.modified {
+			return true
+		}
+	}
+	return false
+}
+
 func (env *Env) Save(backup bool) error {
-	out, err := ioutil.TempFile("/tmp", "gohadoop")
-	if err != nil {
-		return err
+	suffix := ""
+	if backup {
+		suffix = backupSuffix(time.Now())
 	}
-	f, err := os.Open(env.Path)
-	if err != nil {
+	tx := newTransaction(suffix)
+	if err := env.stage(tx); err != nil {
+		tx.Abort()
 		return err
 	}
-	scanner := bufio.NewScanner(f)
-	varlines := make(map[int]*Var)
+	return tx.Commit()
+}
+
+// stage stages env to be written by tx, with only the values of modified variables replaced.
+// A commented out variable is uncommented.
+func (env *Env) stage(tx *transaction) error {
+	if env.unread != nil {
+		return env.unread
+	}
+	if !env.modified() {
+		return nil
+	}
+	modified := []*Var{}
 	for _, v := range env.Vars {
 		if v.modified {
-			varlines[v.line] = v
+			modified = append(modified, v)
 		}
 	}
-	for i := 0; scanner.Scan(); i++ {
-		if v, ok := varlines[i]; ok {
-			out.WriteString("export " + v.Name + "=\"" + v.GetVal() + "\"\n")
-		} else {
-			out.WriteString(scanner.Text() + "\n")
+	sort.Stable(byPosition(modified))
+	out := new(bytes.Buffer)
+	pos := 0
+	for _, v := range modified {
+		switch {
+		case v.unset:
+			start, _ := v.lines(env.stamp.content)
+			out.Write(env.stamp.content[pos:start])
+			if v.Conditional {
+				// an if or a loop whose body is only comments is a syntax error, : does nothing instead
+				indent := len(env.stamp.content[start:v.stmt.start]) - len(bytes.TrimLeft(env.stamp.content[start:v.stmt.start], " \t"))
+				out.Write(env.stamp.content[start : start+indent])
+				out.WriteString(": ")
+				start += indent
+			}
+			out.WriteString("# " + strings.Replace(string(env.stamp.content[start:v.stmt.end]), "\n", "\n# ", -1))
+			pos = v.stmt.end
+		case v.added:
+			out.Write(env.stamp.content[pos:v.stmt.start])
+			if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+				out.WriteString("\n")
+			}
+			if v.Func != "" {
+				out.WriteString(v.callText() + "\n")
+			} else {
+				out.WriteString("export " + v.Name + "=" + quoteValue(v.GetVal(), v.quote) + "\n")
+			}
+			pos = v.stmt.end
+		case v.commented:
+			out.Write(env.stamp.content[pos:v.stmt.start])
+			out.WriteString("export " + v.Name + "=" + quoteValue(v.GetVal(), v.quote))
+			pos = v.stmt.end
+		default:
+			out.Write(env.stamp.content[pos:v.value.start])
+			out.WriteString(quoteValue(v.GetVal(), v.quote))
+			pos = v.value.end
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return err
-	}
-	if err := out.Close(); err != nil {
-		return err
-	}
-	if backup {
-		os.Rename(env.Path, env.Path+time.Now().Format(".2006-01-02_15_04.000"))
-	}
-	return os.Rename(out.Name(), env.Path)
+	out.Write(env.stamp.content[pos:])
+	content := out.Bytes()
+	return tx.Write(env.Path, env.stamp, content, func(written []byte) error {
+		// positions changed, and someone else may have changed the file
+		if err := env.parse(written); err != nil {
+			env.unread = errors.New(env.Path + ": " + err.Error() + ", read it again")
+			return env.unread
+		}
+		return nil
+	})
 }
+
+type byPosition []*Var
+
+func (b byPosition) Len() int           { return len(b) }
+func (b byPosition) Less(i, j int) bool { return b[i].stmt.start < b[j].stmt.start }
+func (b byPosition) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
@@ -1,6 +1,7 @@
 package hadoopconf
 
 import (
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -76,3 +77,44 @@ func TestHadoopEnvWrite(t *testing.T) {
 	Is(env.Get("HADOOP_CLIENT_OPTS").GetVal(), "-Xmx1024m $HADOOP_CLIENT_OPTS")
 	Is(env.Get("JSVC_HOME").GetVal(), "/home/jsvc")
 }
+
+func TestHadoopEnvAddUnset(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"hadoop-env.sh": "export JAVA_HOME=/usr/java\nexport HADOOP_OPTS=\"-server\" # tuned\n[ -z \"$X\" ] && export HADOOP_NICENESS=0\nif true; then\n  export JSVC_HOME=/usr/bin\nfi\n",
+		"yarn-env.sh":   "# export YARN_RESOURCEMANAGER_HEAPSIZE=1000\n",
+		"mapred-env.sh": "export HADOOP_JOB_HISTORYSERVER_HEAPSIZE=1000",
+	})
+	defer os.RemoveAll(dir)
+	env, err := NewEnv(dir)
+	FailOnErr(err)
+	Is(filepath.Base(env.FileFor("YARN_NODEMANAGER_OPTS").Path), "yarn-env.sh")
+	Is(filepath.Base(env.FileFor("HADOOP_MAPRED_PID_DIR").Path), "mapred-env.sh")
+	Is(filepath.Base(env.FileFor("HTTPFS_LOG").Path), "hadoop-env.sh")
+
+	v := env.GetOrAdd("YARN_RESOURCEMANAGER_HEAPSIZE")
+	Is(v.IsSet(), false)
+	v.SetVal("2000")
+	env.GetOrAdd("YARN_NODEMANAGER_OPTS").SetVal("-Xmx1g -Dfoo")
+	env.GetOrAdd("HADOOP_MAPRED_PID_DIR").SetVal("/run/mapred")
+	// added but never set, it's not written
+	env.GetOrAdd("HADOOP_MAPRED_LOG_DIR")
+	unset, err := env.Unset("HADOOP_OPTS")
+	FailOnErr(err)
+	Is(len(unset), 1)
+	_, err = env.Unset("HADOOP_NICENESS")
+	IsNot(err, nil)
+	_, err = env.Unset("JSVC_HOME")
+	FailOnErr(err)
+	FailOnErr(env.Save(false))
+	Is(readFile(filepath.Join(dir, "yarn-env.sh")), "export YARN_RESOURCEMANAGER_HEAPSIZE=2000\nexport YARN_NODEMANAGER_OPTS=\"-Xmx1g -Dfoo\"\n")
+	Is(readFile(filepath.Join(dir, "mapred-env.sh")), "export HADOOP_JOB_HISTORYSERVER_HEAPSIZE=1000\nexport HADOOP_MAPRED_PID_DIR=/run/mapred\n")
+	Is(readFile(filepath.Join(dir, "hadoop-env.sh")), "export JAVA_HOME=/usr/java\n# export HADOOP_OPTS=\"-server\" # tuned\n[ -z \"$X\" ] && export HADOOP_NICENESS=0\nif true; then\n  : # export JSVC_HOME=/usr/bin\nfi\n")
+
+	// the commented out variable is a template now
+	env, err = NewEnv(dir)
+	FailOnErr(err)
+	Is(env.Get("HADOOP_OPTS").IsSet(), false)
+	Is(env.Get("HADOOP_OPTS").Comment, "-server")
+	Is(env.Get("HADOOP_MAPRED_LOG_DIR"), (*Var)(nil))
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"time"
 )
 
 type HadoopConf struct {
@@ -16,6 +17,10 @@ type HadoopConf struct {
 	HdfsSite   *ConfWithDefault
 	MapredSite *ConfWithDefault
 	YarnSite   *ConfWithDefault
+	// Layout is the layout of the installation the default configuration is from, nil if it is none we know
+	Layout *Layout
+	// Sites are all the configuration files, the *-site.xml of each project first
+	Sites []*Site
 }
 
 type HadoopDefaultConf struct {
@@ -23,15 +28,59 @@ type HadoopDefaultConf struct {
 	HdfsSite   ConfSourcer
 	MapredSite ConfSourcer
 	YarnSite   ConfSourcer
+	// Layout is the layout of the installation the jars are in, nil if it is none we know
+	Layout *Layout
+	// Defaults are the default configurations of ConfFiles, by the name of the file
+	Defaults map[string]ConfSourcer
 }
 
+// Confs returns the configuration files, each with its default
+func (c *HadoopConf) Confs() []*ConfWithDefault {
+	confs := []*ConfWithDefault{}
+	for _, site := range c.Sites {
+		if site.Conf != nil {
+			confs = append(confs, site.ConfWithDefault)
+		}
+	}
+	return confs
+}
+
+// stager is a configuration file which a transaction can write
+type stager interface {
+	stage(tx *transaction) error
+}
+
+// Save saves the modified site files, all or nothing. The backups of all files share
+// the same timestamp, so that they can be rolled back together.
 func (c *HadoopConf) Save(backup bool) error {
-	for _, conf := range []*ConfWithDefault{c.CoreSite, c.HdfsSite, c.MapredSite, c.YarnSite} {
-		if err := conf.Conf.(*FileConfiguration).Save(backup); err != nil {
+	suffix := ""
+	if backup {
+		suffix = backupSuffix(time.Now())
+	}
+	tx := newTransaction(suffix)
+	for _, conf := range c.Confs() {
+		if err := conf.Conf.(stager).stage(tx); err != nil {
+			tx.Abort()
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
+}
+
+// Files returns the paths of the site files and the files they include
+func (c *HadoopConf) Files() []string {
+	files := []string{}
+	for _, conf := range c.Confs() {
+		if fc, ok := conf.Conf.(*FileConfiguration); ok {
+			files = append(files, fc.Path)
+			for _, fragment := range fc.Fragments() {
+				files = append(files, fragment.Path)
+			}
+		} else if pc, ok := conf.Conf.(*PropertiesConf); ok {
+			files = append(files, pc.Path)
+		}
+	}
+	return files
 }
 
 func FromConf(coreSite *ConfWithDefault, hdfsSite *ConfWithDefault,
@@ -43,7 +92,14 @@ func FromConf(coreSite *ConfWithDefault, hdfsSite *ConfWithDefault,
 	if mapredSite != nil {
 		confs = append(confs, mapredSite)
 	}
-	return &HadoopConf{confs, coreSite, hdfsSite, mapredSite, yarnSite}
+	c := &HadoopConf{multiSourceConf: confs, CoreSite: coreSite, HdfsSite: hdfsSite, MapredSite: mapredSite, YarnSite: yarnSite}
+	for _, site := range []*Site{{"core-site.xml", coreSite}, {"hdfs-site.xml", hdfsSite},
+		{"mapred-site.xml", mapredSite}, {"yarn-site.xml", yarnSite}} {
+		if site.ConfWithDefault != nil {
+			c.Sites = append(c.Sites, site)
+		}
+	}
+	return c
 }
 
 func anyRegexpMatch(s string, res []*regexp.Regexp) bool {
@@ -107,7 +163,7 @@ func globRegexp(basedirs []string, res []*regexp.Regexp) (string, error) {
 				return "", err
 			}
 			for _, jar := range jars {
-				if anyRegexpMatch(jar.Name(), res) {
+				if anyRegexpMatch(jar.Name(), res) && !otherJar.MatchString(jar.Name()) {
 					return filepath.Join(dir, jar.Name()), nil
 				}
 			}
@@ -167,22 +223,31 @@ func re(pats ...string) []*regexp.Regexp {
 	return res
 }
 
+// Jars reads the default configurations of ConfFiles from the jars of the hadoop installation in
+// basedir, whose layout ProbeLayout finds. It looks where the jars usually are too.
 func Jars(basedir string) (*HadoopDefaultConf, error) {
-	coreDefault, err := getDefault("core-default.xml", re(`hadoop-(common|core)-[0-9.]+-?([a-zA-Z0-9._]+)?\.jar`, "hadoop-common.jar"), basedir,
-		filepath.Join(basedir, "share/hadoop/common"),
-		"/usr/lib/hadoop",
-		"/share/hadoop/common")
-	if err != nil {
-		return nil, err
+	layout := ProbeLayout(basedir)
+	if layout == nil {
+		layout = &Layout{Root: basedir}
 	}
-	hdfsDefault, err := getDefault("hdfs-default.xml", re(`hadoop-(hdfs|core)-[0-9.]+-?([a-zA-Z0-9._]+)?\.jar`), basedir,
-		filepath.Join(basedir, "share/hadoop/hdfs"),
-		filepath.Join(basedir, "hadoop-hdfs"),
-		"/share/hadoop/hdfs",
-		"/usr/lib/hadoop-hdfs")
-	if err != nil {
-		return nil, err
+	defaults := map[string]ConfSourcer{}
+	errs := map[string]error{}
+	for _, f := range ConfFiles {
+		if f.Default == "" {
+			continue
+		}
+		if dflt, err := getDefault(f.Default, f.Jar, f.Dirs(layout, basedir)...); err != nil {
+			errs[f.Name] = err
+		} else {
+			defaults[f.Name] = dflt
+		}
+	}
+	for _, required := range []string{"core-site.xml", "hdfs-site.xml"} {
+		if defaults[required] == nil {
+			return nil, errs[required]
+		}
 	}
+	hdfsDefault := defaults["hdfs-site.xml"]
 	// dfs.*.{kerberos,https}.principal dfs.*.keytab.file does not appear in hdfs-default.xml for some reason
 	for _, role := range []string {"namenode", "namenode.secondary", "datanode"} {
 		hdfsDefault.Set("dfs." + role + ".keytab.file", "")
@@ -190,49 +255,76 @@ func Jars(basedir string) (*HadoopDefaultConf, error) {
 		hdfsDefault.Set("dfs." + role + ".https.principal", "")
 	}
 	hdfsDefault.Set("dfs.datanode.hostname", "")
-	mapredDefault, err := getDefault("mapred-default.xml", re(`hadoop-(mapreduce-client-)?core-[0-9.]+-?([a-zA-Z0-9._]+)?\.jar`), basedir,
-		filepath.Join(basedir, "hadoop-0.20-mapreduce"),
-		filepath.Join(basedir, "hadoop-mapreduce"),
-		filepath.Join(basedir, "share/hadoop/mapreduce"),
-		"/share/hadoop/mapreduce",
-		"/usr/lib/hadoop-0.20-mapreduce",
-		"/usr/lib/hadoop-mapreduce")
-	if mapredDefault == nil {
-		fmt.Println("got", err)
-	}
-	yarnDefault, _ := getDefault("yarn-default.xml", re(`hadoop-yarn-common-[0-9.]+-?([a-zA-Z0-9._]+)?\.jar`), basedir,
-		filepath.Join(basedir, "share/hadoop/yarn"),
-		filepath.Join(basedir, "hadoop-yarn"),
-		"/usr/lib/hadoop-yarn",
-		"/share/hadoop/yarn")
+	if defaults["mapred-site.xml"] == nil {
+		fmt.Println("got", errs["mapred-site.xml"])
+	}
+	if layout.Name == "" {
+		layout = nil
+	}
 	return &HadoopDefaultConf{
-		CoreSite:   coreDefault,
+		CoreSite:   defaults["core-site.xml"],
 		HdfsSite:   hdfsDefault,
-		MapredSite: mapredDefault,
-		YarnSite:   yarnDefault,
+		MapredSite: defaults["mapred-site.xml"],
+		YarnSite:   defaults["yarn-site.xml"],
+		Layout:     layout,
+		Defaults:   defaults,
 	}, nil
 }
 
+// dirs are the directories of a layout, and then the directories to look at if the layout has none
+func dirs(layout []string, fallback ...string) []string {
+	return append(append([]string{}, layout...), fallback...)
+}
+
+// New reads ConfFiles in basedir, or in its etc/hadoop or conf directory. If there's no
+// core-site.xml there, it reads the configuration where the layout of defaultConf's jars keeps it.
 func New(basedir string, defaultConf *HadoopDefaultConf) (conf *HadoopConf, err error) {
 	j := func(s string) string {
 		return filepath.Join(basedir, s)
 	}
-	coreSite, err := getConf("core-site.xml", j("etc/hadoop"), j("conf"), basedir)
+	confDirs := []string{j("etc/hadoop"), j("conf"), basedir}
+	if defaultConf.Layout != nil && !hasFile("core-site.xml", confDirs...) && hasFile("core-site.xml", defaultConf.Layout.Conf...) {
+		confDirs = defaultConf.Layout.Conf
+	}
+	coreSite, err := getConf("core-site.xml", confDirs...)
 	if err != nil {
 		return nil, err
 	}
 	if _, err := os.Stat(coreSite.Path); err != nil {
 		return nil, err
 	}
-	hdfsSite, err := getConf("hdfs-site.xml", j("etc/hadoop"), j("conf"), basedir)
+	hdfsSite, err := getConf("hdfs-site.xml", confDirs...)
 	if err != nil {
 		return nil, err
 	}
-	mapredSite, _ := getConf("mapred-site.xml", j("etc/hadoop"), j("conf"), basedir)
-	yarnSite, _ := getConf("yarn-site.xml", j("etc/hadoop"), j("conf"), basedir)
-	return FromConf(&ConfWithDefault{Default: defaultConf.CoreSite, Conf: coreSite},
+	mapredSite, _ := getConf("mapred-site.xml", confDirs...)
+	yarnSite, _ := getConf("yarn-site.xml", confDirs...)
+	conf = FromConf(&ConfWithDefault{Default: defaultConf.CoreSite, Conf: coreSite},
 		&ConfWithDefault{Default: defaultConf.HdfsSite, Conf: hdfsSite},
 		&ConfWithDefault{Default: defaultConf.MapredSite, Conf: mapredSite},
 		&ConfWithDefault{Default: defaultConf.YarnSite, Conf: yarnSite},
-	), nil
+	)
+	conf.Layout = defaultConf.Layout
+	for _, f := range ConfFiles {
+		if conf.Site(f.Name) != nil {
+			continue
+		}
+		dirs := confDirs
+		if f.ConfDirs != nil {
+			dirs = f.ConfDirs(conf.Layout, filepath.Dir(coreSite.Path))
+		}
+		fc, exists, err := f.read(dirs)
+		if err != nil {
+			continue
+		}
+		dflt := defaultConf.Defaults[f.Name]
+		if dflt == nil {
+			dflt = f.template(fc.Source())
+		}
+		// files which are neither there nor have a default are of components this installation doesn't run
+		if exists || dflt != nil {
+			conf.AddSite(f.Name, &ConfWithDefault{Conf: fc, Default: dflt})
+		}
+	}
+	return conf, nil
 }
@@ -0,0 +1,196 @@
+package hadoopconf
+
+import (
+	"regexp"
+	"strings"
+)
+
+// JvmOption is a single option of a java command line, as found in *_OPTS variables
+type JvmOption struct {
+	// Key identifies the option, options with the same key override each other. It's -Xmx, -Xms,
+	// -Xss or -Xmn for heap and stack sizes, -XX:Name for -XX:+Name, -XX:-Name and -XX:Name=value,
+	// -Dname for system properties, -agentlib:name, -agentpath:path and -javaagent:jar for agents,
+	// -Xname for other -Xname:value options, and the option itself for anything else.
+	Key string
+	// Value of the option: the size of -Xmx, true or false for -XX:+Name and -XX:-Name, the
+	// value after = or : otherwise. Empty if the option has none.
+	Value string
+	// Text is the option as written in the variable, including quotes
+	Text string
+	// Ref is true for references to variables, such as $HADOOP_NAMENODE_OPTS, which are not options
+	Ref bool
+}
+
+var (
+	sizeOption  = regexp.MustCompile(`^(-Xm[xsn]|-Xss)=?(.*)$`)
+	xxOption    = regexp.MustCompile(`^-XX:([+-]?)([A-Za-z0-9_]+)(?:=(.*))?$`)
+	propOption  = regexp.MustCompile(`^-D([^=]+)(?:=(.*))?$`)
+	agentOption = regexp.MustCompile(`^(-agentlib:|-agentpath:|-javaagent:)([^=]*)(?:=(.*))?$`)
+	xOption     = regexp.MustCompile(`^(-X[A-Za-z]+):(.*)$`)
+	varRef      = regexp.MustCompile(`^\$(?:[A-Za-z_][A-Za-z0-9_]*|\{[A-Za-z_][A-Za-z0-9_]*\})$`)
+)
+
+// ParseJvmOption parses a single option, such as -Xmx4g or "-Dname=a value"
+func ParseJvmOption(text string) JvmOption {
+	opt := JvmOption{Key: text, Text: text}
+	s, _ := unquote(text)
+	if m := sizeOption.FindStringSubmatch(s); m != nil {
+		opt.Key, opt.Value = m[1], m[2]
+	} else if m := xxOption.FindStringSubmatch(s); m != nil {
+		opt.Key = "-XX:" + m[2]
+		switch m[1] {
+		case "+":
+			opt.Value = "true"
+		case "-":
+			opt.Value = "false"
+		default:
+			opt.Value = m[3]
+		}
+	} else if m := propOption.FindStringSubmatch(s); m != nil {
+		opt.Key, opt.Value = "-D"+m[1], m[2]
+	} else if m := agentOption.FindStringSubmatch(s); m != nil {
+		opt.Key, opt.Value = m[1]+m[2], m[3]
+	} else if m := xOption.FindStringSubmatch(s); m != nil {
+		opt.Key, opt.Value = m[1], m[2]
+	} else if varRef.MatchString(s) {
+		opt.Ref = true
+	}
+	return opt
+}
+
+// JvmOpts is the value of a *_OPTS variable as a list of options
+type JvmOpts struct {
+	Options []JvmOption
+}
+
+// ParseJvmOpts splits a value into options. Quoted options, and references to other
+// variables, are kept as they are.
+func ParseJvmOpts(value string) *JvmOpts {
+	opts := &JvmOpts{}
+	for _, word := range splitWords(value) {
+		opts.Options = append(opts.Options, ParseJvmOption(word))
+	}
+	return opts
+}
+
+// splitWords splits s on blanks which are not quoted, keeping the quotes
+func splitWords(s string) []string {
+	words := []string{}
+	l := &lexer{src: s}
+	for l.pos < len(l.src) {
+		if c := l.src[l.pos]; c == ' ' || c == '\t' || c == '\n' || c == '\r' {
+			l.pos++
+			continue
+		}
+		start := l.pos
+		if err := l.option(); err != nil {
+			// an unterminated quote, the rest is a single word
+			l.pos = len(l.src)
+		}
+		words = append(words, l.src[start:l.pos])
+	}
+	return words
+}
+
+// option scans a word which ends in a blank only, unlike shell words, as an option may contain any character
+func (l *lexer) option() error {
+	tok := &token{}
+	for l.pos < len(l.src) {
+		var err error
+		switch l.src[l.pos] {
+		case ' ', '\t', '\n', '\r':
+			return nil
+		case '\\':
+			l.pos = min(l.pos+2, len(l.src))
+		case '\'':
+			err = l.single()
+		case '"':
+			err = l.double(tok)
+		case '`':
+			err = l.backquote()
+		case '$':
+			err = l.dollar(tok)
+		default:
+			l.pos++
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (opts *JvmOpts) String() string {
+	texts := []string{}
+	for _, opt := range opts.Options {
+		texts = append(texts, opt.Text)
+	}
+	return strings.Join(texts, " ")
+}
+
+// Get returns the option with the key of option, the last one if there are several, as the JVM does
+func (opts *JvmOpts) Get(option string) (JvmOption, bool) {
+	key := ParseJvmOption(option).Key
+	for i := len(opts.Options) - 1; i >= 0; i-- {
+		if opts.Options[i].Key == key {
+			return opts.Options[i], true
+		}
+	}
+	return JvmOption{}, false
+}
+
+// Set sets an option, such as -Xmx4g, or -Xmx=4g. It replaces the last option with the same key, and
+// removes the others. A new option is added to the end, but before references to variables
+// which end the value, so that $HADOOP_NAMENODE_OPTS stays last, and can override it.
+func (opts *JvmOpts) Set(option string) {
+	opt := ParseJvmOption(option)
+	if sizeOption.MatchString(opt.Text) {
+		// the JVM doesn't accept -Xmx=4g
+		opt.Text = opt.Key + opt.Value
+	}
+	last := -1
+	for i, o := range opts.Options {
+		if o.Key == opt.Key {
+			last = i
+		}
+	}
+	if last >= 0 {
+		opts.Options[last] = opt
+		opts.remove(opt.Key, last)
+		return
+	}
+	i := len(opts.Options)
+	for i > 0 && opts.Options[i-1].Ref {
+		i--
+	}
+	opts.Options = append(opts.Options[:i], append([]JvmOption{opt}, opts.Options[i:]...)...)
+}
+
+// Remove removes the options with the key of option, -Xmx or -Dname are enough. It returns
+// how many were removed.
+func (opts *JvmOpts) Remove(option string) int {
+	return opts.remove(ParseJvmOption(option).Key, -1)
+}
+
+// remove removes the options with key, but the one at keep
+func (opts *JvmOpts) remove(key string, keep int) int {
+	kept := []JvmOption{}
+	for i, o := range opts.Options {
+		if o.Key != key || i == keep {
+			kept = append(kept, o)
+		}
+	}
+	removed := len(opts.Options) - len(kept)
+	opts.Options = kept
+	return removed
+}
+
+// JvmOpts returns the value of v as java options
+func (v *Var) JvmOpts() *JvmOpts {
+	return ParseJvmOpts(v.GetVal())
+}
+
+// SetJvmOpts sets the value of v to opts
+func (v *Var) SetJvmOpts(opts *JvmOpts) {
+	v.SetVal(opts.String())
+}
@@ -0,0 +1,64 @@
+package hadoopconf
+
+import (
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestParseJvmOption(t *testing.T) {
+	Terst(t)
+	for _, c := range []struct{ text, key, value string }{
+		{"-Xmx4g", "-Xmx", "4g"},
+		{"-Xmx=4g", "-Xmx", "4g"},
+		{"-Xss512k", "-Xss", "512k"},
+		{"-XX:+UseG1GC", "-XX:UseG1GC", "true"},
+		{"-XX:-UseParallelGC", "-XX:UseParallelGC", "false"},
+		{"-XX:MaxGCPauseMillis=200", "-XX:MaxGCPauseMillis", "200"},
+		{"-Dhadoop.security.logger=INFO,RFAS", "-Dhadoop.security.logger", "INFO,RFAS"},
+		{`"-Dname=a value"`, "-Dname", "a value"},
+		{"-Dcom.sun.management.jmxremote", "-Dcom.sun.management.jmxremote", ""},
+		{"-agentlib:jdwp=transport=dt_socket,server=y", "-agentlib:jdwp", "transport=dt_socket,server=y"},
+		{"-javaagent:/opt/jmx.jar=8080:conf.yml", "-javaagent:/opt/jmx.jar", "8080:conf.yml"},
+		{"-Xloggc:/var/log/gc.log", "-Xloggc", "/var/log/gc.log"},
+		{"-server", "-server", ""},
+	} {
+		opt := ParseJvmOption(c.text)
+		Is(opt.Key, c.key)
+		Is(opt.Value, c.value)
+		Is(opt.Text, c.text)
+		Is(opt.Ref, false)
+	}
+	Is(ParseJvmOption("$HADOOP_OPTS").Ref, true)
+	Is(ParseJvmOption("${HADOOP_OPTS}").Ref, true)
+}
+
+func TestJvmOpts(t *testing.T) {
+	Terst(t)
+	opts := ParseJvmOpts(`-Dhadoop.security.logger=${HADOOP_SECURITY_LOGGER:-INFO,RFAS} -Xmx1g '-Dx=a b' -Xmx=2g $HADOOP_NAMENODE_OPTS`)
+	Is(len(opts.Options), 5)
+	xmx, ok := opts.Get("-Xmx")
+	Is(ok, true)
+	Is(xmx.Value, "2g")
+	Is(opts.Options[0].Value, "${HADOOP_SECURITY_LOGGER:-INFO,RFAS}")
+
+	// duplicates are removed, the self reference stays last
+	opts.Set("-Xmx4g")
+	opts.Set("-XX:+UseG1GC")
+	Is(opts.String(), `-Dhadoop.security.logger=${HADOOP_SECURITY_LOGGER:-INFO,RFAS} '-Dx=a b' -Xmx4g -XX:+UseG1GC $HADOOP_NAMENODE_OPTS`)
+	opts.Set("-XX:-UseG1GC")
+	Is(opts.Remove("-Dx"), 1)
+	Is(opts.Remove("-Dnone"), 0)
+	Is(opts.String(), `-Dhadoop.security.logger=${HADOOP_SECURITY_LOGGER:-INFO,RFAS} -Xmx4g -XX:-UseG1GC $HADOOP_NAMENODE_OPTS`)
+
+	// a leading self reference is kept first
+	opts = ParseJvmOpts("$HADOOP_OPTS -Djava.net.preferIPv4Stack=true")
+	opts.Set("-Dfoo=bar")
+	Is(opts.String(), "$HADOOP_OPTS -Djava.net.preferIPv4Stack=true -Dfoo=bar")
+
+	v := &Var{Name: "HADOOP_CLIENT_OPTS", val: "-Xmx512m $HADOOP_CLIENT_OPTS"}
+	opts = v.JvmOpts()
+	opts.Set("-Xmx=1g")
+	v.SetJvmOpts(opts)
+	Is(v.GetVal(), "-Xmx1g $HADOOP_CLIENT_OPTS")
+}
@@ -0,0 +1,230 @@
+package hadoopconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Layout is where a hadoop installation keeps its jars, and its configuration
+type Layout struct {
+	// Name of the layout, such as HDP, or hadoop 2 tarball
+	Name string
+	// Root is the directory the layout was found in
+	Root string
+	// Common, Hdfs, Mapreduce and Yarn are the directories with the jars of each project
+	Common, Hdfs, Mapreduce, Yarn []string
+	// Conf are the directories the configuration is usually in
+	Conf []string
+}
+
+// LayoutProbe recognizes the layout of a distribution
+type LayoutProbe struct {
+	Name string
+	// Probe returns the layout of dir, nil if dir is not an installation of this kind
+	Probe func(dir string) *Layout
+	// Roots are where the distribution is usually installed, probed when the given directory is not
+	// an installation of any kind
+	Roots []string
+}
+
+// LayoutProbes are the layouts Jars and New know, the first which matches wins
+var LayoutProbes = []LayoutProbe{
+	{"HDP", probeHDP, []string{"/usr/hdp/current"}},
+	{"CDH parcel", probeParcel, []string{"/opt/cloudera/parcels/CDH"}},
+	{"EMR", probeEMR, []string{"/usr/lib"}},
+	{"Bigtop", probeBigtop, []string{"/usr/lib"}},
+	{"hadoop tarball", probeTarball, nil},
+	{"jars directory", probeJarsDir, nil},
+}
+
+// RegisterLayout adds a probe, which is tried before the others
+func RegisterLayout(probe LayoutProbe) {
+	LayoutProbes = append([]LayoutProbe{probe}, LayoutProbes...)
+}
+
+// ProbeLayout returns the layout of the hadoop installation in dir. If dir is not one, it looks
+// where distributions are usually installed. It returns nil if it finds none.
+func ProbeLayout(dir string) *Layout {
+	for _, p := range LayoutProbes {
+		if l := p.probe(dir); l != nil {
+			return l
+		}
+	}
+	for _, p := range LayoutProbes {
+		for _, root := range p.Roots {
+			if l := p.probe(root); l != nil {
+				return l
+			}
+		}
+	}
+	return nil
+}
+
+func (p LayoutProbe) probe(dir string) *Layout {
+	l := p.Probe(dir)
+	if l == nil {
+		return nil
+	}
+	if l.Name == "" {
+		l.Name = p.Name
+	}
+	if l.Root == "" {
+		l.Root = dir
+	}
+	return l
+}
+
+var (
+	commonJar = re(`^hadoop-(common|core)-[0-9][-a-zA-Z0-9._]*\.jar$`, `^hadoop-common\.jar$`)
+	hdfsJar   = re(`^hadoop-(hdfs|core)-[0-9][-a-zA-Z0-9._]*\.jar$`, `^hadoop-hdfs\.jar$`)
+	mapredJar = re(`^hadoop-(mapreduce-client-)?core-[0-9][-a-zA-Z0-9._]*\.jar$`, `^hadoop-mapreduce-client-core\.jar$`)
+	yarnJar   = re(`^hadoop-yarn-common-[0-9][-a-zA-Z0-9._]*\.jar$`, `^hadoop-yarn-common\.jar$`)
+	// jars which have the name of a project's jar, but not its defaults
+	otherJar = regexp.MustCompile(`-(tests|test-sources|sources|javadoc)\.jar$`)
+)
+
+// findJar returns the name of the first jar in dir which matches res, empty if there's none
+func findJar(dir string, res []*regexp.Regexp) string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, f := range files {
+		if anyRegexpMatch(f.Name(), res) && !otherJar.MatchString(f.Name()) {
+			return f.Name()
+		}
+	}
+	return ""
+}
+
+func isDir(path string) bool {
+	stat, err := os.Stat(path)
+	return err == nil && stat.IsDir()
+}
+
+// hasFile tells if any of dirs has the file name
+func hasFile(name string, dirs ...string) bool {
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// probeHDP recognizes /usr/hdp/current, whose hadoop-*-client links point to the current version
+func probeHDP(dir string) *Layout {
+	for _, current := range []string{filepath.Join(dir, "current"), dir, filepath.Dir(dir)} {
+		client := filepath.Join(current, "hadoop-client")
+		if findJar(client, commonJar) == "" {
+			continue
+		}
+		return &Layout{
+			Root:      current,
+			Common:    []string{client},
+			Hdfs:      []string{filepath.Join(current, "hadoop-hdfs-client"), filepath.Join(current, "hadoop-hdfs-namenode")},
+			Mapreduce: []string{filepath.Join(current, "hadoop-mapreduce-client")},
+			Yarn:      []string{filepath.Join(current, "hadoop-yarn-client")},
+			Conf:      []string{filepath.Join(client, "conf"), "/etc/hadoop/conf"},
+		}
+	}
+	return nil
+}
+
+// libLayout is the layout of a lib directory with a directory per project, as bigtop packages
+// install in /usr/lib
+func libLayout(lib string) *Layout {
+	hadoop := filepath.Join(lib, "hadoop")
+	if findJar(hadoop, commonJar) == "" {
+		return nil
+	}
+	return &Layout{
+		Root:      lib,
+		Common:    []string{hadoop, filepath.Join(hadoop, "client")},
+		Hdfs:      []string{filepath.Join(lib, "hadoop-hdfs")},
+		Mapreduce: []string{filepath.Join(lib, "hadoop-mapreduce"), filepath.Join(lib, "hadoop-0.20-mapreduce")},
+		Yarn:      []string{filepath.Join(lib, "hadoop-yarn")},
+		Conf:      []string{filepath.Join(hadoop, "conf"), filepath.Join(hadoop, "etc", "hadoop"), "/etc/hadoop/conf"},
+	}
+}
+
+// libDirs are the lib directories dir may be, or be in: /usr/lib, or /usr/lib/hadoop
+func libDirs(dir string) []string {
+	return []string{dir, filepath.Dir(dir)}
+}
+
+// probeParcel recognizes a cloudera parcel, /opt/cloudera/parcels/CDH, which has a bigtop lib
+// directory, and its metadata in meta
+func probeParcel(dir string) *Layout {
+	for _, parcel := range []string{dir, filepath.Join(dir, "CDH"), filepath.Dir(dir), filepath.Dir(filepath.Dir(dir))} {
+		if !isDir(filepath.Join(parcel, "meta")) {
+			continue
+		}
+		if l := libLayout(filepath.Join(parcel, "lib")); l != nil {
+			l.Root = parcel
+			return l
+		}
+	}
+	return nil
+}
+
+// probeEMR recognizes amazon's EMR, a bigtop layout whose jars are amzn versions
+func probeEMR(dir string) *Layout {
+	for _, lib := range libDirs(dir) {
+		if l := libLayout(lib); l != nil && strings.Contains(findJar(l.Common[0], commonJar), "-amzn-") {
+			return l
+		}
+	}
+	return nil
+}
+
+// probeBigtop recognizes bigtop packages, which HDP before version 2.2, and CDH packages, use too
+func probeBigtop(dir string) *Layout {
+	for _, lib := range libDirs(dir) {
+		if l := libLayout(lib); l != nil {
+			return l
+		}
+	}
+	return nil
+}
+
+var hadoopVersion = regexp.MustCompile(`^hadoop-(?:common|core)-([0-9]+)\.`)
+
+// probeTarball recognizes the tarballs apache releases, hadoop 2 and 3 with share/hadoop, and hadoop 1
+// with its jars at the top. dir may be the tarball's etc/hadoop too.
+func probeTarball(dir string) *Layout {
+	for _, root := range []string{dir, filepath.Dir(filepath.Dir(dir))} {
+		share := filepath.Join(root, "share", "hadoop")
+		if jar := findJar(filepath.Join(share, "common"), commonJar); jar != "" {
+			l := &Layout{
+				Root:      root,
+				Common:    []string{filepath.Join(share, "common")},
+				Hdfs:      []string{filepath.Join(share, "hdfs")},
+				Mapreduce: []string{filepath.Join(share, "mapreduce")},
+				Yarn:      []string{filepath.Join(share, "yarn")},
+				Conf:      []string{filepath.Join(root, "etc", "hadoop")},
+			}
+			if m := hadoopVersion.FindStringSubmatch(jar); m != nil {
+				l.Name = "hadoop " + m[1] + " tarball"
+			}
+			return l
+		}
+		if jar := findJar(root, commonJar); strings.HasPrefix(jar, "hadoop-core-") {
+			return &Layout{Name: "hadoop 1 tarball", Root: root, Common: []string{root}, Hdfs: []string{root},
+				Mapreduce: []string{root}, Conf: []string{filepath.Join(root, "conf")}}
+		}
+	}
+	return nil
+}
+
+// probeJarsDir recognizes a directory with hadoop's jars, and nothing else we know
+func probeJarsDir(dir string) *Layout {
+	if findJar(dir, commonJar) == "" {
+		return nil
+	}
+	dirs := []string{dir}
+	return &Layout{Common: dirs, Hdfs: dirs, Mapreduce: dirs, Yarn: dirs, Conf: dirs}
+}
@@ -0,0 +1,114 @@
+package hadoopconf
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+// writeJar writes a jar to path, with a default configuration with a single property for each file
+func writeJar(path string, files ...string) {
+	FailOnErr(os.MkdirAll(filepath.Dir(path), 0755))
+	f, err := os.Create(path)
+	FailOnErr(err)
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for _, file := range files {
+		fw, err := w.Create(file)
+		FailOnErr(err)
+		_, err = fw.Write([]byte(`<configuration><property><name>` + file + `</name><value>` +
+			filepath.Base(path) + `</value></property></configuration>`))
+		FailOnErr(err)
+	}
+	FailOnErr(w.Close())
+}
+
+// fakeLayout writes jars with the default configurations to dir, in the directories of each project
+func fakeLayout(dir, version string, common, hdfs, mapred, yarn string) {
+	writeJar(filepath.Join(dir, common, "hadoop-common-"+version+".jar"), "core-default.xml")
+	writeJar(filepath.Join(dir, common, "hadoop-common-"+version+"-tests.jar"))
+	writeJar(filepath.Join(dir, hdfs, "hadoop-hdfs-"+version+".jar"), "hdfs-default.xml")
+	writeJar(filepath.Join(dir, mapred, "hadoop-mapreduce-client-core-"+version+".jar"), "mapred-default.xml")
+	writeJar(filepath.Join(dir, yarn, "hadoop-yarn-common-"+version+".jar"), "yarn-default.xml")
+}
+
+func TestProbeLayout(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"cdh/CDH/meta/parcel.json":                     "{}",
+		"hdp/current/hadoop-client/conf/core-site.xml": "<configuration/>",
+		"hdp/current/hadoop-client/conf/hdfs-site.xml": "<configuration/>",
+	})
+	defer os.RemoveAll(dir)
+	fakeLayout(filepath.Join(dir, "hdp", "current"), "2.7.3.2.6.5.0-292",
+		"hadoop-client", "hadoop-hdfs-client", "hadoop-mapreduce-client", "hadoop-yarn-client")
+	fakeLayout(filepath.Join(dir, "cdh", "CDH", "lib"), "2.6.0-cdh5.16.2",
+		"hadoop", "hadoop-hdfs", "hadoop-mapreduce", "hadoop-yarn")
+	fakeLayout(filepath.Join(dir, "bigtop", "usr", "lib"), "2.10.1",
+		"hadoop", "hadoop-hdfs", "hadoop-mapreduce", "hadoop-yarn")
+	fakeLayout(filepath.Join(dir, "emr", "usr", "lib"), "2.8.5-amzn-5",
+		"hadoop", "hadoop-hdfs", "hadoop-mapreduce", "hadoop-yarn")
+	fakeLayout(filepath.Join(dir, "hadoop-3.3.6"), "3.3.6",
+		"share/hadoop/common", "share/hadoop/hdfs", "share/hadoop/mapreduce", "share/hadoop/yarn")
+	j := func(path string) string {
+		return filepath.Join(dir, path)
+	}
+
+	for probed, expected := range map[string]Layout{
+		"hdp":                              {Name: "HDP", Root: j("hdp/current")},
+		"hdp/current/hadoop-client":        {Name: "HDP", Root: j("hdp/current")},
+		"cdh":                              {Name: "CDH parcel", Root: j("cdh/CDH")},
+		"cdh/CDH/lib/hadoop":               {Name: "CDH parcel", Root: j("cdh/CDH")},
+		"bigtop/usr/lib/hadoop":            {Name: "Bigtop", Root: j("bigtop/usr/lib")},
+		"emr/usr/lib":                      {Name: "EMR", Root: j("emr/usr/lib")},
+		"hadoop-3.3.6":                     {Name: "hadoop 3 tarball", Root: j("hadoop-3.3.6")},
+		"hadoop-3.3.6/etc/hadoop":          {Name: "hadoop 3 tarball", Root: j("hadoop-3.3.6")},
+		"hadoop-3.3.6/share/hadoop/common": {Name: "jars directory", Root: j("hadoop-3.3.6/share/hadoop/common")},
+	} {
+		l := ProbeLayout(j(probed))
+		if IsNot(l, (*Layout)(nil)) {
+			Is(l.Name, expected.Name)
+			Is(l.Root, expected.Root)
+		}
+	}
+	Is(ProbeLayout(filepath.Join(tempDir, hadoop2)).Name, "hadoop 2 tarball")
+	Is(ProbeLayout(filepath.Join(tempDir, hadoop1)).Name, "hadoop 1 tarball")
+
+	// the jars of each project are found, and not the tests jar
+	for probed, version := range map[string]string{
+		"hdp":          "2.7.3.2.6.5.0-292",
+		"cdh":          "2.6.0-cdh5.16.2",
+		"emr/usr/lib":  "2.8.5-amzn-5",
+		"hadoop-3.3.6": "3.3.6",
+	} {
+		jars, err := Jars(j(probed))
+		FailOnErr(err)
+		Is(jars.Layout.Root, ProbeLayout(j(probed)).Root)
+		Is(jars.CoreSite.Get("core-default.xml"), "hadoop-common-"+version+".jar")
+		Is(jars.HdfsSite.Get("hdfs-default.xml"), "hadoop-hdfs-"+version+".jar")
+		Is(jars.MapredSite.Get("mapred-default.xml"), "hadoop-mapreduce-client-core-"+version+".jar")
+		Is(jars.YarnSite.Get("yarn-default.xml"), "hadoop-yarn-common-"+version+".jar")
+	}
+
+	// the configuration is where the layout keeps it
+	jars, err := Jars(j("hdp"))
+	FailOnErr(err)
+	conf, err := New(j("hdp"), jars)
+	FailOnErr(err)
+	Is(conf.CoreSite.Conf.Source(), j("hdp/current/hadoop-client/conf/core-site.xml"))
+	Is(conf.Layout.Name, "HDP")
+
+	// registered probes are tried first
+	RegisterLayout(LayoutProbe{Name: "custom", Probe: func(dir string) *Layout {
+		if filepath.Base(dir) != "custom" {
+			return nil
+		}
+		return probeTarball(filepath.Join(filepath.Dir(dir), "hadoop-3.3.6"))
+	}})
+	defer func() { LayoutProbes = LayoutProbes[1:] }()
+	Is(ProbeLayout(j("custom")).Name, "hadoop 3 tarball")
+	Is(ProbeLayout(j("hadoop-3.3.6")).Root, j("hadoop-3.3.6"))
+}
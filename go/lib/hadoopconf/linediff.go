@@ -0,0 +1,145 @@
+package hadoopconf
+
+import (
+	"strings"
+)
+
+// lcsTable returns a table whose [i][j] entry is the length of the longest common
+// subsequence of x[i:] and y[j:]
+func lcsTable(x, y []string) [][]int {
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	return lcs
+}
+
+// DiffLines returns the lines which differ between a and b, prefixed with "-" for
+// lines only in a, and "+" for lines only in b, in the order they appear
+func DiffLines(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	lcs := lcsTable(x, y)
+	diff := []string{}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i++
+			j++
+		case j == len(y) || i < len(x) && lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+x[i])
+			i++
+		default:
+			diff = append(diff, "+"+y[j])
+			j++
+		}
+	}
+	return diff
+}
+
+// hunk replaces the lines start to end of the base by lines
+type hunk struct {
+	start, end int
+	lines      []string
+}
+
+// hunks returns the changes from base to other, in order
+func hunks(base, other []string) []hunk {
+	lcs := lcsTable(base, other)
+	hs := []hunk{}
+	var cur *hunk
+	i, j := 0, 0
+	for i < len(base) || j < len(other) {
+		if i < len(base) && j < len(other) && base[i] == other[j] {
+			if cur != nil {
+				hs = append(hs, *cur)
+				cur = nil
+			}
+			i++
+			j++
+			continue
+		}
+		if cur == nil {
+			cur = &hunk{start: i, end: i}
+		}
+		if j == len(other) || i < len(base) && lcs[i+1][j] >= lcs[i][j+1] {
+			i++
+			cur.end = i
+		} else {
+			cur.lines = append(cur.lines, other[j])
+			j++
+		}
+	}
+	if cur != nil {
+		hs = append(hs, *cur)
+	}
+	return hs
+}
+
+// apply returns base[start:end] with the hunks, which must all be within it, applied
+func apply(base []string, start, end int, hs []hunk) []string {
+	lines := []string{}
+	for _, h := range hs {
+		lines = append(lines, base[start:h.start]...)
+		lines = append(lines, h.lines...)
+		start = h.end
+	}
+	return append(lines, base[start:end]...)
+}
+
+// Merge3 merges the changes from base to a and from base to b, line by line. ok is
+// false if both changed the same, or adjacent, lines differently.
+func Merge3(base, a, b string) (merged string, ok bool) {
+	x := strings.Split(base, "\n")
+	ha, hb := hunks(x, strings.Split(a, "\n")), hunks(x, strings.Split(b, "\n"))
+	lines := []string{}
+	pos := 0
+	for len(ha) > 0 || len(hb) > 0 {
+		// a group is a maximal run of overlapping, or touching, hunks from both sides
+		var ga, gb []hunk
+		start, end := 0, 0
+		if len(hb) == 0 || len(ha) > 0 && ha[0].start <= hb[0].start {
+			start, end = ha[0].start, ha[0].end
+		} else {
+			start, end = hb[0].start, hb[0].end
+		}
+		for {
+			if len(ha) > 0 && ha[0].start <= end {
+				if ha[0].end > end {
+					end = ha[0].end
+				}
+				ga, ha = append(ga, ha[0]), ha[1:]
+			} else if len(hb) > 0 && hb[0].start <= end {
+				if hb[0].end > end {
+					end = hb[0].end
+				}
+				gb, hb = append(gb, hb[0]), hb[1:]
+			} else {
+				break
+			}
+		}
+		lines = append(lines, x[pos:start]...)
+		fromA, fromB := apply(x, start, end, ga), apply(x, start, end, gb)
+		switch {
+		case len(gb) == 0:
+			lines = append(lines, fromA...)
+		case len(ga) == 0 || strings.Join(fromA, "\n") == strings.Join(fromB, "\n"):
+			lines = append(lines, fromB...)
+		default:
+			return "", false
+		}
+		pos = end
+	}
+	return strings.Join(append(lines, x[pos:]...), "\n"), true
+}
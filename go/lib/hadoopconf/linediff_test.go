@@ -0,0 +1,15 @@
+package hadoopconf
+
+import (
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestDiffLines(t *testing.T) {
+	Terst(t)
+	Is(DiffLines("a\nb\nc", "a\nb\nc"), []string{})
+	Is(DiffLines("a\nb\nc", "a\nx\nc\nd"), []string{"-b", "+x", "+d"})
+	Is(DiffLines("", "a"), []string{"-", "+a"})
+	Is(DiffLines("a\nb", "b"), []string{"-a"})
+}
@@ -0,0 +1,297 @@
+package hadoopconf
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	}
+	return "error"
+}
+
+// ParseSeverity parses the name of a severity, as returned by Severity.String
+func ParseSeverity(s string) (Severity, bool) {
+	for _, severity := range []Severity{Info, Warning, Error} {
+		if severity.String() == s {
+			return severity, true
+		}
+	}
+	return Info, false
+}
+
+// Finding is a problem a lint rule found in the configuration
+type Finding struct {
+	Severity Severity
+	Rule     string
+	// the properties and environment variables involved
+	Keys    []string
+	Message string
+}
+
+// LintContext is what lint rules check
+type LintContext struct {
+	Conf *HadoopConf
+	// may be nil, if there are no *-env.sh files
+	Env Envs
+	// memory of the machines, in bytes, 0 if unknown
+	MachineMemory int64
+}
+
+// LintRule checks relationships between properties, which no single value can tell is wrong
+type LintRule struct {
+	Name        string
+	Description string
+	Check       func(ctx *LintContext) []Finding
+}
+
+// LintRules are the rules Lint checks by default, append to it to add rules
+var LintRules = []LintRule{
+	{"max-allocation", "containers requested by mapreduce must fit yarn's maximal allocation", lintMaxAllocation},
+	{"min-max-allocation", "yarn's minimal allocation must not exceed the maximal one, which must fit a node", lintMinMaxAllocation},
+	{"vcores", "vcores requested by mapreduce must fit yarn's allocation and nodes", lintVcores},
+	{"heap-vs-container", "-Xmx of tasks must leave room in their container for non heap memory", lintHeapVsContainer},
+	{"heapsize-vs-ram", "daemon heaps and containers must fit the machine's memory", lintHeapsizeVsRAM},
+}
+
+// Lint runs rules over ctx, and returns the findings, most severe first
+func Lint(ctx *LintContext, rules []LintRule) []Finding {
+	findings := []Finding{}
+	for _, rule := range rules {
+		for _, f := range rule.Check(ctx) {
+			f.Rule = rule.Name
+			findings = append(findings, f)
+		}
+	}
+	sort.Stable(bySeverity(findings))
+	return findings
+}
+
+type bySeverity []Finding
+
+func (s bySeverity) Len() int           { return len(s) }
+func (s bySeverity) Less(i, j int) bool { return s[i].Severity > s[j].Severity }
+func (s bySeverity) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// lintInt returns the value of key, and false if it's not set or not a number.
+// Values which are not numbers are reported by Validate, not by the rules.
+func (ctx *LintContext) lintInt(key string) (int64, bool) {
+	v, err := ctx.Conf.GetInt(key, -1)
+	return v, err == nil && v >= 0
+}
+
+func assignment(key string, v int64) string {
+	return key + "=" + strconv.FormatInt(v, 10)
+}
+
+// containers mapreduce requests, and the properties which define their memory, vcores and heap
+var mapreduceContainers = []struct {
+	name, memory, vcores, opts string
+}{
+	{"map", "mapreduce.map.memory.mb", "mapreduce.map.cpu.vcores", "mapreduce.map.java.opts"},
+	{"reduce", "mapreduce.reduce.memory.mb", "mapreduce.reduce.cpu.vcores", "mapreduce.reduce.java.opts"},
+	{"application master", "yarn.app.mapreduce.am.resource.mb", "yarn.app.mapreduce.am.resource.cpu-vcores", "yarn.app.mapreduce.am.command-opts"},
+}
+
+func lintMaxAllocation(ctx *LintContext) []Finding {
+	findings := []Finding{}
+	max, hasMax := ctx.lintInt("yarn.scheduler.maximum-allocation-mb")
+	min, hasMin := ctx.lintInt("yarn.scheduler.minimum-allocation-mb")
+	for _, c := range mapreduceContainers {
+		mem, ok := ctx.lintInt(c.memory)
+		if !ok {
+			continue
+		}
+		if hasMax && mem > max {
+			findings = append(findings, Finding{Error, "", []string{c.memory, "yarn.scheduler.maximum-allocation-mb"},
+				assignment(c.memory, mem) + " exceeds " + assignment("yarn.scheduler.maximum-allocation-mb", max) +
+					", " + c.name + " containers will be refused"})
+		}
+		if hasMin && mem < min {
+			findings = append(findings, Finding{Info, "", []string{c.memory, "yarn.scheduler.minimum-allocation-mb"},
+				assignment(c.memory, mem) + " is below " + assignment("yarn.scheduler.minimum-allocation-mb", min) +
+					", " + c.name + " containers will get " + strconv.FormatInt(min, 10) + "MB"})
+		}
+	}
+	return findings
+}
+
+func lintMinMaxAllocation(ctx *LintContext) []Finding {
+	findings := []Finding{}
+	max, hasMax := ctx.lintInt("yarn.scheduler.maximum-allocation-mb")
+	min, hasMin := ctx.lintInt("yarn.scheduler.minimum-allocation-mb")
+	node, hasNode := ctx.lintInt("yarn.nodemanager.resource.memory-mb")
+	if hasMin && hasMax && min > max {
+		findings = append(findings, Finding{Error, "", []string{"yarn.scheduler.minimum-allocation-mb", "yarn.scheduler.maximum-allocation-mb"},
+			assignment("yarn.scheduler.minimum-allocation-mb", min) + " exceeds " +
+				assignment("yarn.scheduler.maximum-allocation-mb", max) + ", the resource manager will not start"})
+	}
+	if hasMax && hasNode && max > node {
+		findings = append(findings, Finding{Warning, "", []string{"yarn.scheduler.maximum-allocation-mb", "yarn.nodemanager.resource.memory-mb"},
+			assignment("yarn.scheduler.maximum-allocation-mb", max) + " exceeds " +
+				assignment("yarn.nodemanager.resource.memory-mb", node) + ", containers that large will never be scheduled"})
+	}
+	if hasMin && hasNode && min > node {
+		findings = append(findings, Finding{Error, "", []string{"yarn.scheduler.minimum-allocation-mb", "yarn.nodemanager.resource.memory-mb"},
+			assignment("yarn.scheduler.minimum-allocation-mb", min) + " exceeds " +
+				assignment("yarn.nodemanager.resource.memory-mb", node) + ", no container fits a node"})
+	}
+	return findings
+}
+
+func lintVcores(ctx *LintContext) []Finding {
+	findings := []Finding{}
+	max, hasMax := ctx.lintInt("yarn.scheduler.maximum-allocation-vcores")
+	min, hasMin := ctx.lintInt("yarn.scheduler.minimum-allocation-vcores")
+	node, hasNode := ctx.lintInt("yarn.nodemanager.resource.cpu-vcores")
+	if hasMin && hasMax && min > max {
+		findings = append(findings, Finding{Error, "", []string{"yarn.scheduler.minimum-allocation-vcores", "yarn.scheduler.maximum-allocation-vcores"},
+			assignment("yarn.scheduler.minimum-allocation-vcores", min) + " exceeds " +
+				assignment("yarn.scheduler.maximum-allocation-vcores", max)})
+	}
+	// yarn's defaults allow allocations larger than a node, so only actual requests are checked against nodes
+	for _, c := range mapreduceContainers {
+		vcores, ok := ctx.lintInt(c.vcores)
+		switch {
+		case !ok:
+		case hasMax && vcores > max:
+			findings = append(findings, Finding{Error, "", []string{c.vcores, "yarn.scheduler.maximum-allocation-vcores"},
+				assignment(c.vcores, vcores) + " exceeds " + assignment("yarn.scheduler.maximum-allocation-vcores", max) +
+					", " + c.name + " containers will be refused"})
+		case hasNode && vcores > node:
+			findings = append(findings, Finding{Warning, "", []string{c.vcores, "yarn.nodemanager.resource.cpu-vcores"},
+				assignment(c.vcores, vcores) + " exceeds " + assignment("yarn.nodemanager.resource.cpu-vcores", node) +
+					", " + c.name + " containers will never be scheduled"})
+		}
+	}
+	return findings
+}
+
+// MaxHeap returns the heap size -Xmx sets in java options, in bytes.
+// The last -Xmx wins, as in the JVM.
+func MaxHeap(opts string) (int64, bool) {
+	xmx, ok := ParseJvmOpts(opts).Get("-Xmx")
+	if !ok {
+		return 0, false
+	}
+	heap, err := ParseBytes(xmx.Value)
+	return heap, err == nil
+}
+
+// the part of a container that's left for the JVM itself, threads stacks and direct buffers,
+// hadoop 3 sizes the heap to 80% of the container for the same reason
+const heapRatio = 0.8
+
+func lintHeapVsContainer(ctx *LintContext) []Finding {
+	findings := []Finding{}
+	for _, c := range mapreduceContainers {
+		mem, ok := ctx.lintInt(c.memory)
+		if !ok {
+			continue
+		}
+		optsKey := c.opts
+		opts, src, alias := ctx.Conf.AliasSourceGet(optsKey)
+		if alias != "" {
+			optsKey = alias
+		}
+		if src == NoSource && c.name != "application master" {
+			// tasks fall back to the options of hadoop 1
+			optsKey = "mapred.child.java.opts"
+			opts, _ = ctx.Conf.SourceGet(optsKey)
+		}
+		opts, _ = ctx.Conf.Expand(opts)
+		heap, ok := MaxHeap(opts)
+		if !ok {
+			continue
+		}
+		heapMB := heap >> 20
+		switch {
+		case heapMB > mem:
+			findings = append(findings, Finding{Error, "", []string{optsKey, c.memory},
+				"-Xmx" + strconv.FormatInt(heapMB, 10) + "m in " + optsKey + " exceeds " + assignment(c.memory, mem) +
+					", " + c.name + " containers will be killed for running beyond memory limits"})
+		case float64(heapMB) > float64(mem)*heapRatio:
+			findings = append(findings, Finding{Warning, "", []string{optsKey, c.memory},
+				"-Xmx" + strconv.FormatInt(heapMB, 10) + "m in " + optsKey + " is more than " +
+					strconv.Itoa(int(heapRatio*100)) + "% of " + assignment(c.memory, mem) +
+					", leaving little room for non heap memory"})
+		}
+	}
+	return findings
+}
+
+// heapEnv returns the value in MB of a heap size variable, such as HADOOP_HEAPSIZE_MAX
+func (ctx *LintContext) heapEnv(name string) (int64, bool) {
+	v := ctx.Env.Get(name)
+	if v == nil || !v.IsSet() || v.GetVal() == "" {
+		return 0, false
+	}
+	heap, err := parseHeapsize(v.GetVal())
+	return heap >> 20, err == nil
+}
+
+func lintHeapsizeVsRAM(ctx *LintContext) []Finding {
+	findings := []Finding{}
+	if ctx.MachineMemory <= 0 {
+		return findings
+	}
+	ramMB := ctx.MachineMemory >> 20
+	ram := "machine memory of " + strconv.FormatInt(ramMB, 10) + "MB"
+	if ctx.Env != nil {
+		for _, name := range ctx.Env.Keys() {
+			if mb, ok := ctx.heapEnv(name); ok && heapsizeVar.MatchString(name) && mb > ramMB {
+				findings = append(findings, Finding{Error, "", []string{name},
+					name + "=" + ctx.Env.Get(name).GetVal() + " exceeds the " + ram})
+			}
+		}
+	}
+	node, ok := ctx.lintInt("yarn.nodemanager.resource.memory-mb")
+	if !ok {
+		return findings
+	}
+	if node > ramMB {
+		findings = append(findings, Finding{Error, "", []string{"yarn.nodemanager.resource.memory-mb"},
+			assignment("yarn.nodemanager.resource.memory-mb", node) + " exceeds the " + ram})
+		return findings
+	}
+	// the node manager and data node run next to the containers, with the heaps their scripts
+	// give them, hadoop 2's default is 1000MB
+	daemons := int64(0)
+	keys := []string{"yarn.nodemanager.resource.memory-mb"}
+	for _, name := range []string{"nodemanager", "datanode"} {
+		heap := int64(1000)
+		if ctx.Env != nil {
+			d, _ := FindDaemon(name)
+			e := ctx.Env.Effective(d, Environment{})
+			// hadoop 3 has no default, java's is a quarter of the memory
+			heap = ramMB / 4
+			if xmx, ok := MaxHeap(e.Opts.String()); ok {
+				heap = xmx >> 20
+			}
+			keys = append(keys, e.Daemon.Heap...)
+		}
+		daemons += heap
+	}
+	if node+daemons > ramMB {
+		findings = append(findings, Finding{Warning, "", unionKeys(keys, nil),
+			assignment("yarn.nodemanager.resource.memory-mb", node) + " and " + strconv.FormatInt(daemons, 10) +
+				"MB of node manager and data node heaps exceed the " + ram})
+	}
+	return findings
+}
+
+var heapsizeVar = regexp.MustCompile(`^[A-Z_]*HEAPSIZE(_MAX)?$`)
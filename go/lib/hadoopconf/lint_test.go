@@ -0,0 +1,99 @@
+package hadoopconf
+
+import (
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func testLintConf(t *testing.T, yarnSite, mapredSite string) *HadoopConf {
+	yarn, err := NewGeneratedConfFromString(Source{"yarn-site.xml", LocalFile}, yarnSite)
+	FailOnErr(err)
+	yarnDefault, err := NewGeneratedConfFromString(Source{"yarn-default.xml", FileFromJar}, `<configuration>
+  <property><name>yarn.scheduler.minimum-allocation-mb</name><value>1024</value></property>
+  <property><name>yarn.scheduler.maximum-allocation-mb</name><value>8192</value></property>
+  <property><name>yarn.scheduler.minimum-allocation-vcores</name><value>1</value></property>
+  <property><name>yarn.scheduler.maximum-allocation-vcores</name><value>32</value></property>
+  <property><name>yarn.nodemanager.resource.memory-mb</name><value>8192</value></property>
+  <property><name>yarn.nodemanager.resource.cpu-vcores</name><value>8</value></property>
+</configuration>`)
+	FailOnErr(err)
+	mapred, err := NewGeneratedConfFromString(Source{"mapred-site.xml", LocalFile}, mapredSite)
+	FailOnErr(err)
+	mapredDefault, err := NewGeneratedConfFromString(Source{"mapred-default.xml", FileFromJar}, `<configuration>
+  <property><name>mapreduce.map.memory.mb</name><value>1024</value></property>
+  <property><name>mapreduce.reduce.memory.mb</name><value>1024</value></property>
+  <property><name>mapred.child.java.opts</name><value>-Xmx200m</value></property>
+  <property><name>yarn.app.mapreduce.am.resource.mb</name><value>1536</value></property>
+  <property><name>yarn.app.mapreduce.am.command-opts</name><value>-Xmx1024m</value></property>
+</configuration>`)
+	FailOnErr(err)
+	return FromConf(&ConfWithDefault{nil, nil}, &ConfWithDefault{nil, nil},
+		&ConfWithDefault{mapred, mapredDefault}, &ConfWithDefault{yarn, yarnDefault})
+}
+
+func rules(findings []Finding) []string {
+	names := []string{}
+	for _, f := range findings {
+		names = append(names, f.Severity.String()+" "+f.Rule)
+	}
+	return names
+}
+
+func TestLintDefaults(t *testing.T) {
+	Terst(t)
+	c := testLintConf(t, `<configuration/>`, `<configuration/>`)
+	Is(rules(Lint(&LintContext{Conf: c}, LintRules)), []string{})
+}
+
+func TestLint(t *testing.T) {
+	Terst(t)
+	c := testLintConf(t, `<configuration>
+  <property><name>yarn.scheduler.maximum-allocation-mb</name><value>4096</value></property>
+  <property><name>yarn.scheduler.maximum-allocation-vcores</name><value>4</value></property>
+</configuration>`, `<configuration>
+  <property><name>mapreduce.map.memory.mb</name><value>6144</value></property>
+  <property><name>mapreduce.map.cpu.vcores</name><value>8</value></property>
+  <property><name>mapreduce.reduce.memory.mb</name><value>512</value></property>
+  <property><name>mapred.reduce.child.java.opts</name><value>-Xmx256m -Xmx1g</value></property>
+  <property><name>yarn.app.mapreduce.am.command-opts</name><value>-Xmx1300m</value></property>
+</configuration>`)
+	findings := Lint(&LintContext{Conf: c}, LintRules)
+	Is(rules(findings), []string{
+		"error max-allocation",
+		"error vcores",
+		"error heap-vs-container",
+		"warning heap-vs-container",
+		"info max-allocation",
+	})
+	Is(findings[2].Keys, []string{"mapred.reduce.child.java.opts", "mapreduce.reduce.memory.mb"})
+	Is(findings[0].Message, "mapreduce.map.memory.mb=6144 exceeds yarn.scheduler.maximum-allocation-mb=4096, map containers will be refused")
+}
+
+func TestLintMemory(t *testing.T) {
+	Terst(t)
+	c := testLintConf(t, `<configuration>
+  <property><name>yarn.nodemanager.resource.memory-mb</name><value>7168</value></property>
+</configuration>`, `<configuration/>`)
+	env := Envs{&Env{Path: "hadoop-env.sh", Vars: []*Var{{Name: "HADOOP_HEAPSIZE", val: "2000"}}}}
+	findings := Lint(&LintContext{c, env, 8 << 30}, LintRules)
+	Is(rules(findings), []string{"warning min-max-allocation", "warning heapsize-vs-ram"})
+	findings = Lint(&LintContext{c, env, 1 << 30}, LintRules)
+	Is(rules(findings), []string{"error heapsize-vs-ram", "error heapsize-vs-ram", "warning min-max-allocation"})
+	// hadoop 3's heap sizes may have units
+	env = Envs{&Env{Path: "hadoop-env.sh", Vars: []*Var{{Name: "HADOOP_HEAPSIZE_MAX", val: "96g"}}}}
+	findings = Lint(&LintContext{c, env, 64 << 30}, LintRules)
+	Is(rules(findings), []string{"error heapsize-vs-ram", "warning min-max-allocation", "warning heapsize-vs-ram"})
+	Is(findings[0].Message, "HADOOP_HEAPSIZE_MAX=96g exceeds the machine memory of 65536MB")
+	env = Envs{&Env{Path: "hadoop-env.sh", Vars: []*Var{{Name: "HADOOP_HEAPSIZE_MAX", val: "30g"}}}}
+	findings = Lint(&LintContext{c, env, 64 << 30}, LintRules)
+	Is(rules(findings), []string{"warning min-max-allocation", "warning heapsize-vs-ram"})
+	env = Envs{&Env{Path: "hadoop-env.sh", Vars: []*Var{{Name: "HADOOP_HEAPSIZE_MAX", val: "4096"}}}}
+	findings = Lint(&LintContext{c, env, 64 << 30}, LintRules)
+	Is(rules(findings), []string{"warning min-max-allocation"})
+	heap, ok := MaxHeap("-Xms1g -Xmx2g -Dfoo")
+	Is(ok, true)
+	Is(heap, int64(2<<30))
+	_, ok = MaxHeap("-Dfoo=-Xmx1g")
+	Is(ok, false)
+}
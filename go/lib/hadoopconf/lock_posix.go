@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package hadoopconf
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockDir takes an exclusive advisory lock of dir, waiting for other hadoopconf
+// processes which hold it. The lock is held until unlock is called.
+func lockDir(dir string) (unlock func(), err error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(d.Fd()), syscall.LOCK_EX); err != nil {
+		d.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(d.Fd()), syscall.LOCK_UN)
+		d.Close()
+	}, nil
+}
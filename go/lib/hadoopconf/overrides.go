@@ -0,0 +1,58 @@
+package hadoopconf
+
+import (
+	"strings"
+)
+
+type OverrideKind int
+
+const (
+	// the site sets a key to its default value
+	Redundant OverrideKind = iota
+	// the site sets a key to a value other than its default
+	Overridden
+	// no *-default.xml knows the key, a typo, or a key of another project
+	Unknown
+)
+
+func (k OverrideKind) String() string {
+	switch k {
+	case Redundant:
+		return "redundant"
+	case Overridden:
+		return "override"
+	}
+	return "unknown"
+}
+
+// Override is a key set in a site file, compared with its default
+type Override struct {
+	Key    string
+	Value  string
+	Source Source
+	// NoSource if the key is unknown
+	Default       string
+	DefaultSource Source
+	Kind          OverrideKind
+}
+
+// Overrides compares every key in the site files with the default of the key, in any
+// of the *-default.xml. Deprecated keys are compared with the default of their current name.
+func (c *HadoopConf) Overrides() []Override {
+	overrides := []Override{}
+	for _, site := range c.HadoopSites() {
+		for _, key := range site.Conf.Keys() {
+			v, src := site.Conf.SourceGet(key)
+			o := Override{Key: key, Value: v, Source: src, Kind: Unknown}
+			if p, dfltSrc := c.DefaultProperty(key); p != nil {
+				o.Default, o.DefaultSource = p.Value, dfltSrc
+				o.Kind = Overridden
+				if strings.TrimSpace(v) == strings.TrimSpace(p.Value) {
+					o.Kind = Redundant
+				}
+			}
+			overrides = append(overrides, o)
+		}
+	}
+	return overrides
+}
@@ -0,0 +1,40 @@
+package hadoopconf
+
+import (
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestOverrides(t *testing.T) {
+	Terst(t)
+	site, err := NewGeneratedConfFromString(Source{"core-site.xml", LocalFile}, `<configuration>
+  <property><name>io.file.buffer.size</name><value> 4096 </value></property>
+  <property><name>fs.defaultFS</name><value>hdfs://nn:8020</value></property>
+  <property><name>fs.defualtFS</name><value>hdfs://nn:8020</value></property>
+  <property><name>dfs.replication</name><value>2</value></property>
+</configuration>`)
+	FailOnErr(err)
+	dflt, err := NewGeneratedConfFromString(Source{"core-default.xml", FileFromJar}, `<configuration>
+  <property><name>io.file.buffer.size</name><value>4096</value></property>
+  <property><name>fs.defaultFS</name><value>file:///</value></property>
+</configuration>`)
+	FailOnErr(err)
+	hdfsDefault, err := NewGeneratedConfFromString(Source{"hdfs-default.xml", FileFromJar}, `<configuration>
+  <property><name>dfs.replication</name><value>3</value></property>
+</configuration>`)
+	FailOnErr(err)
+	c := FromConf(&ConfWithDefault{site, dflt}, &ConfWithDefault{nil, hdfsDefault}, nil, nil)
+	overrides := c.Overrides()
+	if Is(len(overrides), 4) {
+		Is(overrides[0].Kind, Redundant)
+		Is(overrides[1].Kind, Overridden)
+		Is(overrides[1].Default, "file:///")
+		Is(overrides[1].DefaultSource.Source, "core-default.xml")
+		Is(overrides[2].Kind, Unknown)
+		Is(overrides[2].DefaultSource, NoSource)
+		// defaults of other files count as well
+		Is(overrides[3].Kind, Overridden)
+		Is(overrides[3].DefaultSource.Source, "hdfs-default.xml")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package hadoopconf
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of a file, -1 if unknown
+func fileOwner(info os.FileInfo) (uid, gid int) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid)
+	}
+	return -1, -1
+}
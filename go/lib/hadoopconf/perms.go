@@ -0,0 +1,63 @@
+package hadoopconf
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// FilePolicy is the mode and ownership of files Save creates. Files it replaces keep
+// the mode and ownership they had.
+type FilePolicy struct {
+	Mode os.FileMode
+	// -1 leaves the owner, or group, of the user saving the file
+	Uid, Gid int
+}
+
+// NewFiles is the policy of files created by Save
+var NewFiles = FilePolicy{Mode: 0644, Uid: -1, Gid: -1}
+
+// ParseOwner parses "user", "user:group" or ":group", by name or numeric id.
+// The missing part is -1.
+func ParseOwner(owner string) (uid, gid int, err error) {
+	parts := strings.SplitN(owner, ":", 2)
+	if uid, err = lookupID(parts[0], func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", errors.New("no such user " + name)
+		}
+		return u.Uid, nil
+	}); err != nil {
+		return -1, -1, err
+	}
+	if len(parts) == 1 {
+		return uid, -1, nil
+	}
+	if gid, err = lookupID(parts[1], func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", errors.New("no such group " + name)
+		}
+		return g.Gid, nil
+	}); err != nil {
+		return -1, -1, err
+	}
+	return uid, gid, nil
+}
+
+// lookupID returns the numeric id of a user or group, -1 if name is empty
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
@@ -0,0 +1,303 @@
+package hadoopconf
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PropertiesConf is a configuration in a java properties file, such as spark-defaults.conf or zoo.cfg.
+// Like FileConfiguration, saving it rewrites only the properties which changed.
+type PropertiesConf struct {
+	Path  string
+	props []*propLine
+	stamp *fileStamp
+	// sep separates keys from values of added properties, the file's own separator if it has properties
+	sep string
+}
+
+// propLine is a property of a properties file, which may span lines which end with \
+type propLine struct {
+	key, value string
+	// the key and the separator, as they are in the file
+	rawKey, sep string
+	// the lines of the property in the file
+	start, end int
+	modified   bool
+	deleted    bool
+	added      bool
+}
+
+// NewPropertiesConf reads the properties file in path, a missing file is read as empty.
+// sep separates the keys from the values of the properties added to a file which has none.
+func NewPropertiesConf(path, sep string) (*PropertiesConf, error) {
+	content, stamp, err := readStamped(path)
+	if err != nil {
+		return nil, err
+	}
+	pc := &PropertiesConf{Path: path, stamp: stamp, sep: sep}
+	pc.parse(content)
+	return pc, nil
+}
+
+func (pc *PropertiesConf) parse(content []byte) {
+	pc.props = []*propLine{}
+	for start := 0; start < len(content); {
+		// a line which ends with an odd number of backslashes continues in the next one
+		end, logical := start, ""
+		for {
+			eol := bytes.IndexByte(content[end:], '\n')
+			line := ""
+			if eol == -1 {
+				line, end = string(content[end:]), len(content)
+			} else {
+				line, end = string(content[end:end+eol]), end+eol+1
+			}
+			line = strings.TrimRight(line, "\r")
+			if logical != "" {
+				line = strings.TrimLeft(line, " \t\f")
+			}
+			backslashes := len(line) - len(strings.TrimRight(line, `\`))
+			if backslashes%2 == 0 || end == len(content) {
+				logical += line
+				break
+			}
+			logical += line[:len(line)-1]
+		}
+		if p := parsePropertiesLine(logical); p != nil {
+			p.start, p.end = start, end
+			pc.props = append(pc.props, p)
+			if len(pc.props) == 1 {
+				pc.sep = normalSep(p.sep)
+			}
+		}
+		start = end
+	}
+}
+
+// parsePropertiesLine parses the property in line, nil if it's blank or a comment
+func parsePropertiesLine(line string) *propLine {
+	line = strings.TrimLeft(line, " \t\f")
+	if line == "" || line[0] == '#' || line[0] == '!' {
+		return nil
+	}
+	i := 0
+	for ; i < len(line) && !strings.ContainsRune("=: \t\f", rune(line[i])); i++ {
+		if line[i] == '\\' {
+			i++
+		}
+	}
+	if i > len(line) {
+		i = len(line)
+	}
+	j := i
+	for j < len(line) && strings.ContainsRune(" \t\f", rune(line[j])) {
+		j++
+	}
+	if j < len(line) && (line[j] == '=' || line[j] == ':') {
+		j++
+	}
+	for j < len(line) && strings.ContainsRune(" \t\f", rune(line[j])) {
+		j++
+	}
+	return &propLine{key: unescapeProperty(line[:i]), value: unescapeProperty(line[j:]), rawKey: line[:i], sep: line[i:j]}
+}
+
+// normalSep is the separator of added properties like sep, that of the file's first property:
+// = for key=value, or a space for the aligned columns of spark-defaults.conf
+func normalSep(sep string) string {
+	if strings.ContainsAny(sep, "=:") {
+		return sep
+	}
+	return " "
+}
+
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	out := []rune{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			out = append(out, r)
+			i += size - 1
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			out = append(out, '\t')
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 'f':
+			out = append(out, '\f')
+		case 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					out = append(out, rune(r))
+					i += 4
+					continue
+				}
+			}
+			out = append(out, 'u')
+		default:
+			r, size := utf8.DecodeRuneInString(s[i:])
+			out = append(out, r)
+			i += size - 1
+		}
+	}
+	return string(out)
+}
+
+// escapeProperty escapes s so that it reads back as s, as a key if key is true, and as a value otherwise
+func escapeProperty(s string, key bool) string {
+	out := new(bytes.Buffer)
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			out.WriteString(`\\`)
+		case r == '\n':
+			out.WriteString(`\n`)
+		case r == '\r':
+			out.WriteString(`\r`)
+		case r == '\t' && (key || i == 0):
+			out.WriteString(`\t`)
+		case r == ' ' && (key || i == 0):
+			out.WriteString(`\ `)
+		case key && (r == '=' || r == ':' || (i == 0 && (r == '#' || r == '!'))):
+			out.WriteString(`\` + string(r))
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// get returns the property which is in effect for key, the last one
+func (pc *PropertiesConf) get(key string) *propLine {
+	var last *propLine
+	for _, p := range pc.props {
+		if p.key == key && !p.deleted {
+			last = p
+		}
+	}
+	return last
+}
+
+func (pc *PropertiesConf) Keys() []string {
+	keys := []string{}
+	seen := make(map[string]bool)
+	for _, p := range pc.props {
+		if !p.deleted && !seen[p.key] {
+			keys = append(keys, p.key)
+			seen[p.key] = true
+		}
+	}
+	return keys
+}
+
+func (pc *PropertiesConf) Get(key string) string {
+	v, _ := pc.SourceGet(key)
+	return v
+}
+
+// Set sets the value of the property in effect for key, or adds a property to the end of the file
+func (pc *PropertiesConf) Set(key, val string) (oldval string) {
+	p := pc.get(key)
+	if p == nil {
+		end := len(pc.stamp.content)
+		p = &propLine{key: key, rawKey: escapeProperty(key, true), sep: pc.sep, start: end, end: end, added: true}
+		pc.props = append(pc.props, p)
+	}
+	oldval = p.value
+	p.value = val
+	p.modified = true
+	return oldval
+}
+
+// Delete removes all properties of key
+func (pc *PropertiesConf) Delete(key string) (oldval string) {
+	oldval = pc.Get(key)
+	for _, p := range pc.props {
+		if p.key == key {
+			p.deleted = true
+		}
+	}
+	return oldval
+}
+
+func (pc *PropertiesConf) Source() string {
+	return pc.Path
+}
+
+func (pc *PropertiesConf) SourceGet(key string) (value string, src Source) {
+	if p := pc.get(key); p != nil {
+		return p.value, Source{pc.Path, LocalFile}
+	}
+	return "", NoSource
+}
+
+func (pc *PropertiesConf) modified() bool {
+	for _, p := range pc.props {
+		if p.modified || p.deleted {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes returns the file with the changed properties, the rest of it is as it was read
+func (pc *PropertiesConf) Bytes() []byte {
+	content := pc.stamp.content
+	out := new(bytes.Buffer)
+	pos := 0
+	for _, p := range pc.props {
+		if !p.modified && !p.deleted {
+			continue
+		}
+		out.Write(content[pos:p.start])
+		pos = p.end
+		if p.deleted {
+			continue
+		}
+		if out.Len() > 0 && out.Bytes()[out.Len()-1] != '\n' {
+			out.WriteString("\n")
+		}
+		out.WriteString(p.rawKey + p.sep + escapeProperty(p.value, false))
+		if p.added || bytes.HasSuffix(content[p.start:p.end], []byte("\n")) {
+			out.WriteString("\n")
+		}
+	}
+	out.Write(content[pos:])
+	return out.Bytes()
+}
+
+// Save saves the properties file, if backup = true will keep a backup
+func (pc *PropertiesConf) Save(backup bool) error {
+	suffix := ""
+	if backup {
+		suffix = backupSuffix(time.Now())
+	}
+	tx := newTransaction(suffix)
+	if err := pc.stage(tx); err != nil {
+		tx.Abort()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (pc *PropertiesConf) stage(tx *transaction) error {
+	if !pc.modified() {
+		return nil
+	}
+	return tx.Write(pc.Path, pc.stamp, pc.Bytes(), func(written []byte) error {
+		// positions changed, and someone else may have changed the file
+		pc.parse(written)
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package hadoopconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+const sparkDefaults = `# Default system properties included when running spark-submit.
+spark.master                     spark://master:7077
+spark.eventLog.enabled           true
+# spark.serializer                 org.apache.spark.serializer.KryoSerializer
+spark.driver.extraJavaOptions    -XX:+PrintGCDetails \
+                                 -Dkey=value
+spark.executor.memory            1g
+spark.executor.memory            2g
+weird\:key\ name = a\tb\u00e9
+`
+
+func TestPropertiesConf(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"spark-defaults.conf": sparkDefaults, "zoo.cfg": "tickTime=2000\ndataDir=/var/zk"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spark-defaults.conf")
+	pc, err := NewPropertiesConf(path, "=")
+	FailOnErr(err)
+	Is(strings.Join(pc.Keys(), " "), "spark.master spark.eventLog.enabled spark.driver.extraJavaOptions spark.executor.memory weird:key name")
+	Is(pc.Get("spark.master"), "spark://master:7077")
+	Is(pc.Get("spark.driver.extraJavaOptions"), "-XX:+PrintGCDetails -Dkey=value")
+	// the last one wins, as in java.util.Properties
+	Is(pc.Get("spark.executor.memory"), "2g")
+	Is(pc.Get("weird:key name"), "a\tb\u00e9")
+	_, src := pc.SourceGet("spark.serializer")
+	Is(src, NoSource)
+
+	// only the changed lines are written, added properties are separated like the others
+	Is(pc.Set("spark.executor.memory", "4g"), "2g")
+	pc.Set("spark.driver.extraJavaOptions", "-Dx")
+	pc.Delete("spark.eventLog.enabled")
+	pc.Set("spark.serializer", "org.apache.spark.serializer.KryoSerializer")
+	pc.Set("new key", `C:\dir`)
+	FailOnErr(pc.Save(false))
+	expected := `# Default system properties included when running spark-submit.
+spark.master                     spark://master:7077
+# spark.serializer                 org.apache.spark.serializer.KryoSerializer
+spark.driver.extraJavaOptions    -Dx
+spark.executor.memory            1g
+spark.executor.memory            4g
+weird\:key\ name = a\tb\u00e9
+spark.serializer org.apache.spark.serializer.KryoSerializer
+new\ key C:\\dir
+`
+	Is(readFile(path), expected)
+	pc, err = NewPropertiesConf(path, "=")
+	FailOnErr(err)
+	Is(pc.Get("new key"), `C:\dir`)
+	Is(pc.Get("spark.executor.memory"), "4g")
+
+	// a file without a newline at its end
+	zoo, err := NewPropertiesConf(filepath.Join(dir, "zoo.cfg"), " ")
+	FailOnErr(err)
+	zoo.Set("dataDir", "/data/zk")
+	zoo.Set("clientPort", "2181")
+	FailOnErr(zoo.Save(false))
+	Is(readFile(filepath.Join(dir, "zoo.cfg")), "tickTime=2000\ndataDir=/data/zk\nclientPort=2181\n")
+
+	// a new file
+	created, err := NewPropertiesConf(filepath.Join(dir, "new.cfg"), "=")
+	FailOnErr(err)
+	created.Set("a", "b")
+	FailOnErr(created.Save(false))
+	Is(readFile(filepath.Join(dir, "new.cfg")), "a=b\n")
+}
@@ -0,0 +1,102 @@
+package hadoopconf
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// KeyRoute sends keys which start with Prefix, and which no file has yet, to File
+type KeyRoute struct {
+	Prefix string
+	File   string
+	// Free is true for keys no default lists, such as the users of hadoop.proxyuser.*
+	Free bool
+}
+
+// KeyRoutes are the files of keys by their prefix, the longest prefix of a key wins
+var KeyRoutes = []KeyRoute{
+	{"fs.", "core-site.xml", false},
+	{"io.", "core-site.xml", false},
+	{"ipc.", "core-site.xml", false},
+	{"net.", "core-site.xml", false},
+	{"ha.", "core-site.xml", false},
+	{"file.", "core-site.xml", false},
+	{"ftp.", "core-site.xml", false},
+	{"s3.", "core-site.xml", false},
+	{"s3native.", "core-site.xml", false},
+	{"topology.", "core-site.xml", false},
+	{"hadoop.", "core-site.xml", false},
+	{"hadoop.proxyuser.", "core-site.xml", true},
+	{"fs.s3a.bucket.", "core-site.xml", true},
+	{"dfs.", "hdfs-site.xml", false},
+	{"dfs.federation.router.", "hdfs-rbf-site.xml", false},
+	{"mapred.", "mapred-site.xml", false},
+	{"mapreduce.", "mapred-site.xml", false},
+	{"yarn.", "yarn-site.xml", false},
+	{"yarn.scheduler.capacity.", "capacity-scheduler.xml", true},
+	{"security.", "hadoop-policy.xml", true},
+	{"ssl.server.", "ssl-server.xml", true},
+	{"ssl.client.", "ssl-client.xml", true},
+	{"hadoop.kms.", "kms-site.xml", false},
+	{"httpfs.", "httpfs-site.xml", false},
+	{"hbase.", "hbase-site.xml", false},
+	{"hive.", "hive-site.xml", false},
+	{"datanucleus.", "hive-site.xml", false},
+	{"javax.jdo.", "hive-site.xml", false},
+	{"tez.", "tez-site.xml", true},
+	{"spark.", "spark-defaults.conf", true},
+	{"tickTime", "zoo.cfg", true},
+	{"dataDir", "zoo.cfg", true},
+	{"dataLogDir", "zoo.cfg", true},
+	{"clientPort", "zoo.cfg", true},
+	{"initLimit", "zoo.cfg", true},
+	{"syncLimit", "zoo.cfg", true},
+	{"maxClientCnxns", "zoo.cfg", true},
+	{"autopurge.", "zoo.cfg", true},
+	{"server.", "zoo.cfg", true},
+}
+
+// RouteKey returns the route of key, the one with the longest prefix of key, false if there's none
+func RouteKey(key string) (route KeyRoute, ok bool) {
+	for _, r := range KeyRoutes {
+		if strings.HasPrefix(key, r.Prefix) && len(r.Prefix) > len(route.Prefix) {
+			route, ok = r, true
+		}
+	}
+	return route, ok
+}
+
+// SiteFor returns the file key belongs in: the first which has it, or whose default has it, and
+// otherwise the file of its KeyRoutes. unknown is true if that file has a default, which doesn't
+// know key, such as a key with a typo. Keys which extend a key of the default, such as
+// dfs.namenode.rpc-address.ns1.nn1, and keys of free routes are known.
+func (c *HadoopConf) SiteFor(key string) (site *Site, unknown bool, err error) {
+	if sites := c.SitesOf(key); len(sites) > 0 {
+		return sites[0], false, nil
+	}
+	route, ok := RouteKey(key)
+	if !ok {
+		return nil, false, errors.New("cannot tell which file " + key + " belongs in, use --file to choose it")
+	}
+	if site = c.Site(route.File); site == nil {
+		return nil, false, errors.New(key + " belongs in " + route.File + ", which is not in the configuration, use --file to choose another file")
+	}
+	if route.Free || site.Default == nil {
+		return site, false, nil
+	}
+	for _, known := range site.Default.Keys() {
+		if strings.HasPrefix(key, known+".") {
+			return site, false, nil
+		}
+	}
+	return site, true, nil
+}
+
+// DefaultName is the name of the default configuration of the site, empty if it has none
+func (site *Site) DefaultName() string {
+	if site.Default == nil {
+		return ""
+	}
+	return filepath.Base(site.Default.Source())
+}
@@ -0,0 +1,106 @@
+package hadoopconf
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestRouteKey(t *testing.T) {
+	Terst(t)
+	for key, file := range map[string]string{
+		"fs.defaultFS":                          "core-site.xml",
+		"hadoop.proxyuser.hbase.hosts":          "core-site.xml",
+		"hadoop.kms.key.provider.uri":           "kms-site.xml",
+		"dfs.replication":                       "hdfs-site.xml",
+		"dfs.federation.router.rpc-address":     "hdfs-rbf-site.xml",
+		"mapreduce.framework.name":              "mapred-site.xml",
+		"yarn.scheduler.capacity.root.queues":   "capacity-scheduler.xml",
+		"yarn.resourcemanager.hostname":         "yarn-site.xml",
+		"javax.jdo.option.ConnectionURL":        "hive-site.xml",
+		"spark.executor.memory":                 "spark-defaults.conf",
+		"server.1":                              "zoo.cfg",
+		"ssl.server.keystore.location":          "ssl-server.xml",
+		"security.client.protocol.acl":          "hadoop-policy.xml",
+		"fs.s3a.bucket.logs.endpoint":           "core-site.xml",
+		"hbase.zookeeper.quorum":                "hbase-site.xml",
+		"tez.lib.uris":                          "tez-site.xml",
+		"httpfs.authentication.type":            "httpfs-site.xml",
+		"datanucleus.schema.autoCreateAll":      "hive-site.xml",
+		"dfs.namenode.rpc-address.ns1.nn1":      "hdfs-site.xml",
+		"yarn.scheduler.capacity.root.a.queues": "capacity-scheduler.xml",
+	} {
+		route, ok := RouteKey(key)
+		Is(ok, true)
+		Is(route.File, file)
+	}
+	_, ok := RouteKey("foo.bar")
+	Is(ok, false)
+}
+
+func TestSiteFor(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"etc/hadoop/core-site.xml":  `<configuration><property><name>custom.key</name><value>1</value></property></configuration>`,
+		"etc/hadoop/hdfs-site.xml":  "<configuration/>",
+		"etc/hadoop/ssl-server.xml": "<configuration/>",
+	})
+	defer os.RemoveAll(dir)
+	fakeLayout(dir, "3.3.6", "share/hadoop/common", "share/hadoop/hdfs", "share/hadoop/mapreduce", "share/hadoop/yarn")
+	// a default with a key which HA configurations extend with the nameservice and the namenode
+	f, err := os.Create(filepath.Join(dir, "share/hadoop/hdfs/hadoop-hdfs-3.3.6.jar"))
+	FailOnErr(err)
+	w := zip.NewWriter(f)
+	fw, err := w.Create("hdfs-default.xml")
+	FailOnErr(err)
+	_, err = fw.Write([]byte(`<configuration><property><name>dfs.namenode.rpc-address</name><value></value></property></configuration>`))
+	FailOnErr(err)
+	FailOnErr(w.Close())
+	FailOnErr(f.Close())
+
+	jars, err := Jars(dir)
+	FailOnErr(err)
+	c, err := New(dir, jars)
+	FailOnErr(err)
+	for key, expected := range map[string]struct {
+		file    string
+		unknown bool
+	}{
+		// keys which are set, or in a default, stay where they are
+		"custom.key":               {"core-site.xml", false},
+		"dfs.namenode.rpc-address": {"hdfs-site.xml", false},
+		// keys which extend a key of the default
+		"dfs.namenode.rpc-address.ns1.nn1": {"hdfs-site.xml", false},
+		// keys no default lists
+		"hadoop.proxyuser.hbase.hosts": {"core-site.xml", false},
+		"ssl.server.keystore.type":     {"ssl-server.xml", false},
+		// typos
+		"dfs.replcation":  {"hdfs-site.xml", true},
+		"yarn.nodemanger": {"yarn-site.xml", true},
+	} {
+		site, unknown, err := c.SiteFor(key)
+		FailOnErr(err)
+		Is(site.Name, expected.file)
+		Is(unknown, expected.unknown)
+	}
+	Is(c.Site("hdfs-site").DefaultName(), "hdfs-default.xml")
+	Is(c.Site("ssl-server").DefaultName(), "")
+
+	_, _, err = c.SiteFor("foo.bar")
+	IsNot(err, nil)
+	// hbase-site.xml is not in the configuration
+	_, _, err = c.SiteFor("hbase.rootdir")
+	IsNot(err, nil)
+
+	site, _, err := c.SiteFor("hadoop.proxyuser.hbase.hosts")
+	FailOnErr(err)
+	site.Set("hadoop.proxyuser.hbase.hosts", "*")
+	FailOnErr(c.Save(false))
+	c, err = New(dir, jars)
+	FailOnErr(err)
+	Is(c.Site("core-site").Get("hadoop.proxyuser.hbase.hosts"), "*")
+	Is(c.Site("core-site").Get("custom.key"), "1")
+}
@@ -0,0 +1,669 @@
+package hadoopconf
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A lexer and parser of the subset of bash found in *-env.sh files. It finds the variable
+// assignments, and where exactly in the file their values are, so that a value can be replaced
+// without touching anything around it. Commands other than assignments are skipped.
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	// ; & && || | ;; ( ) and redirections
+	opToken
+	newlineToken
+	commentToken
+)
+
+type token struct {
+	kind tokenKind
+	// the source text, including quotes
+	text string
+	span span
+	// ${NAME:=value} assignments within a word
+	defaults []*Var
+}
+
+type lexer struct {
+	src  string
+	pos  int
+	base int
+	// heredoc delimiters whose bodies start at the next newline
+	heredocs []string
+	// within double quotes, where single quotes are not quotes
+	inDouble bool
+}
+
+// errorAt returns an error which says where in the file offset is
+func (l *lexer) errorAt(offset int, msg string) error {
+	line := strings.Count(l.src[:offset-l.base], "\n") + 1
+	return errors.New("line " + strconv.Itoa(line) + ": " + msg)
+}
+
+func isOpChar(c byte) bool {
+	return strings.IndexByte(";&|()<>", c) >= 0
+}
+
+var twoCharOps = []string{";;", "&&", "||", ">>", "<<", ">&", "<&", "&>", ">|"}
+
+// next returns the next token, nil at the end of input
+func (l *lexer) next() (*token, error) {
+	for l.pos < len(l.src) {
+		switch {
+		case l.src[l.pos] == ' ' || l.src[l.pos] == '\t' || l.src[l.pos] == '\r':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "\\\n"):
+			l.pos += 2
+		default:
+			return l.token()
+		}
+	}
+	return nil, nil
+}
+
+func (l *lexer) token() (*token, error) {
+	start := l.pos
+	tok := &token{span: span{l.base + start, 0}}
+	switch c := l.src[l.pos]; {
+	case c == '\n':
+		l.pos++
+		tok.kind = newlineToken
+		if err := l.skipHeredocs(); err != nil {
+			return nil, err
+		}
+	case c == '#':
+		tok.kind = commentToken
+		for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+			l.pos++
+		}
+	case isOpChar(c):
+		tok.kind = opToken
+		l.pos++
+		for _, op := range twoCharOps {
+			if strings.HasPrefix(l.src[start:], op) {
+				l.pos = start + len(op)
+			}
+		}
+	default:
+		tok.kind = wordToken
+		if err := l.word(tok); err != nil {
+			return nil, err
+		}
+	}
+	tok.text = l.src[start:l.pos]
+	tok.span.end = l.base + l.pos
+	return tok, nil
+}
+
+// skipHeredocs skips the bodies of the here documents started on the line which just ended
+func (l *lexer) skipHeredocs() error {
+	for _, delim := range l.heredocs {
+		for {
+			if l.pos >= len(l.src) {
+				return l.errorAt(l.base+l.pos, "here document not terminated by "+delim)
+			}
+			end := strings.IndexByte(l.src[l.pos:], '\n')
+			if end < 0 {
+				end = len(l.src) - l.pos
+			}
+			line := l.src[l.pos : l.pos+end]
+			l.pos = min(l.pos+end+1, len(l.src))
+			if strings.TrimLeft(line, "\t") == delim {
+				break
+			}
+		}
+	}
+	l.heredocs = nil
+	return nil
+}
+
+// word scans a word, up to an unquoted blank or operator
+func (l *lexer) word(tok *token) error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n' || isOpChar(c):
+			return nil
+		case c == '\\':
+			l.pos = min(l.pos+2, len(l.src))
+		case c == '\'':
+			if err := l.single(); err != nil {
+				return err
+			}
+		case c == '"':
+			if err := l.double(tok); err != nil {
+				return err
+			}
+		case c == '`':
+			if err := l.backquote(); err != nil {
+				return err
+			}
+		case c == '$':
+			if err := l.dollar(tok); err != nil {
+				return err
+			}
+		default:
+			l.pos++
+		}
+	}
+	return nil
+}
+
+func (l *lexer) single() error {
+	start := l.pos
+	end := strings.IndexByte(l.src[l.pos+1:], '\'')
+	if end < 0 {
+		return l.errorAt(l.base+start, "unterminated '")
+	}
+	l.pos += end + 2
+	return nil
+}
+
+func (l *lexer) double(tok *token) error {
+	start := l.pos
+	defer func(inDouble bool) { l.inDouble = inDouble }(l.inDouble)
+	l.inDouble = true
+	l.pos++
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '"':
+			l.pos++
+			return nil
+		case '\\':
+			l.pos = min(l.pos+2, len(l.src))
+		case '`':
+			if err := l.backquote(); err != nil {
+				return err
+			}
+		case '$':
+			if err := l.dollar(tok); err != nil {
+				return err
+			}
+		default:
+			l.pos++
+		}
+	}
+	return l.errorAt(l.base+start, "unterminated \"")
+}
+
+func (l *lexer) backquote() error {
+	start := l.pos
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+		case '`':
+			l.pos++
+			return nil
+		}
+	}
+	return l.errorAt(l.base+start, "unterminated `")
+}
+
+var defaultAssignment = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*):?=`)
+
+// dollar scans $NAME, ${...}, $(...) and $((...))
+func (l *lexer) dollar(tok *token) error {
+	start := l.pos
+	l.pos++
+	if l.pos >= len(l.src) {
+		return nil
+	}
+	opening := l.src[l.pos]
+	var closing byte
+	switch opening {
+	case '{':
+		closing = '}'
+	case '(':
+		closing = ')'
+	case '\'':
+		// $'...' is quoted like '...', but with escapes
+		for l.pos++; l.pos < len(l.src) && l.src[l.pos] != '\''; l.pos++ {
+			if l.src[l.pos] == '\\' {
+				l.pos++
+			}
+		}
+		if l.pos >= len(l.src) {
+			return l.errorAt(l.base+start, "unterminated $'")
+		}
+		l.pos++
+		return nil
+	default:
+		return nil
+	}
+	// a $(...) is a new quoting context, a ${...} is not
+	defer func(inDouble bool) { l.inDouble = inDouble }(l.inDouble)
+	if opening == '(' {
+		l.inDouble = false
+	}
+	l.pos++
+	valueStart := l.pos
+	if m := defaultAssignment.FindStringSubmatch(l.src[start:]); m != nil {
+		valueStart = start + len(m[0])
+	}
+	// nested braces, or parentheses, and quotes within
+	for depth := 1; l.pos < len(l.src); {
+		switch c := l.src[l.pos]; {
+		case c == closing:
+			depth--
+			l.pos++
+			if depth == 0 {
+				if m := defaultAssignment.FindStringSubmatch(l.src[start:]); m != nil {
+					value := span{l.base + valueStart, l.base + l.pos - 1}
+					tok.defaults = append(tok.defaults, &Var{Name: m[1], IfUnset: true, stmt: span{l.base + start, value.end + 1}, value: value})
+				}
+				return nil
+			}
+		case c == opening:
+			depth++
+			l.pos++
+		case c == '\\':
+			l.pos = min(l.pos+2, len(l.src))
+		case c == '\'' && !l.inDouble:
+			if err := l.single(); err != nil {
+				return err
+			}
+		case c == '"':
+			if err := l.double(tok); err != nil {
+				return err
+			}
+		case c == '`':
+			if err := l.backquote(); err != nil {
+				return err
+			}
+		case c == '$':
+			if err := l.dollar(tok); err != nil {
+				return err
+			}
+		default:
+			l.pos++
+		}
+	}
+	return l.errorAt(l.base+start, "unterminated $"+string(opening))
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+var assignmentWord = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\+?=`)
+
+var nameWord = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// unquote returns the value of a word as the env files show it: a word which is entirely quoted
+// is shown without the quotes, other words as they are, expansions are not expanded. quote is
+// the quote character of a quoted word, 0 otherwise.
+func unquote(word string) (value string, quote byte) {
+	if len(word) >= 2 && (word[0] == '\'' || word[0] == '"') {
+		l := &lexer{src: word}
+		var err error
+		if word[0] == '\'' {
+			err = l.single()
+		} else {
+			err = l.double(&token{})
+		}
+		if err == nil && l.pos == len(word) {
+			value, quote = word[1:len(word)-1], word[0]
+		}
+	}
+	if quote == 0 {
+		value = word
+	}
+	if quote != '\'' {
+		value = strings.Replace(value, "\\\n", "", -1)
+	}
+	return value, quote
+}
+
+// quoteValue returns value as a word, quoted like a word which was quoted with quote. The value
+// of a double quoted word is the text within the quotes, with its escapes and expansions, so a
+// value which is valid within double quotes is kept as is. Other values are taken literally, and
+// escaped, but for references to variables, such as $HADOOP_OPTS, which values show unexpanded.
+func quoteValue(value string, quote byte) string {
+	switch {
+	case quote == '\'':
+		return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+	case quote == 0 && value != "" && !strings.ContainsAny(value, " \t\n'\"\\`;&|()<>#"):
+		return value
+	}
+	l := &lexer{src: `"` + value + `"`}
+	if err := l.double(&token{}); err == nil && l.pos == len(l.src) {
+		return l.src
+	}
+	quoted := []byte{'"'}
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '$' && variableRef.MatchString(value[i:]):
+		case strings.IndexByte("\"$`\\", c) >= 0:
+			quoted = append(quoted, '\\')
+		}
+		quoted = append(quoted, value[i])
+	}
+	return string(append(quoted, '"'))
+}
+
+// variableRef is a reference to a variable, $NAME or ${...}
+var variableRef = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*|\{[^}]*\})`)
+
+// shell reserved words which may precede a command
+var reservedWords = map[string]int{
+	"if": 1, "while": 1, "until": 1, "for": 1, "case": 1, "{": 1,
+	"fi": -1, "done": -1, "esac": -1, "}": -1,
+	"then": 0, "else": 0, "elif": 0, "do": 0, "!": 0,
+}
+
+// parseShell returns the variables assigned in src, which starts at offset base of path.
+// Assignments commented out with "# export NAME=value" are returned as commented vars.
+func parseShell(path string, src string, base int) ([]*Var, error) {
+	p := &shellParser{path: path, src: src, base: base, exported: map[string]bool{}}
+	if err := p.parse(&lexer{src: src, base: base}, false); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	// assignments are added when their command ends, which may be after a comment or a ${NAME:=value}
+	sort.Stable(byPosition(p.vars))
+	return p.vars, nil
+}
+
+type shellParser struct {
+	path     string
+	src      string
+	base     int
+	vars     []*Var
+	exported map[string]bool
+}
+
+// condition is a test which must succeed, or fail, for a conditional assignment to take effect
+type condition struct {
+	// the test command, such as [ "$HADOOP_HEAPSIZE" = "" ]
+	test string
+	// the test must fail, as the test of a previous branch of an if, or the command before ||
+	negate bool
+	// loops, case and functions, which we don't evaluate
+	unknown bool
+	// where the test is in the file, as the test runs once for all the assignments it guards
+	at int
+}
+
+// branch is a compound command the parser is in
+type branch struct {
+	// the tests of the previous branches of an if, which failed if this branch runs
+	prior []condition
+	// the test of this branch, nil in an else branch
+	test *condition
+	// false for anything but an if
+	known bool
+}
+
+// conditions returns the conditions of an assignment within branches, or after an and-or list
+func conditions(branches []*branch, andOr *condition) []condition {
+	conds := []condition{}
+	for _, b := range branches {
+		if !b.known {
+			conds = append(conds, condition{unknown: true})
+			continue
+		}
+		for _, c := range b.prior {
+			c.negate = true
+			conds = append(conds, c)
+		}
+		if b.test != nil {
+			conds = append(conds, *b.test)
+		}
+	}
+	if andOr != nil {
+		conds = append(conds, *andOr)
+	}
+	return conds
+}
+
+func (p *shellParser) text(s span) string {
+	return p.src[s.start-p.base : s.end-p.base]
+}
+
+func (p *shellParser) parse(l *lexer, commented bool) error {
+	var (
+		// the next word is a command name, or an assignment
+		cmdStart = true
+		export   = false
+		// whether the command is in a compound command or after && or ||
+		depth   = 0
+		andOr   = false
+		heredoc = false
+		// assignments before a command name affect only the environment of that command
+		pending = []*Var{}
+		// the if, loops and so on we're in, and the test of the and-or list
+		branches  = []*branch{}
+		andOrCond *condition
+		// where the and-or list, and the test of an if, start. -1 before their first word
+		listStart = -1
+		testStart = -1
+		// a call of a hadoop 3 function, such as hadoop_add_param
+		call *shellCall
+	)
+	top := func() *branch {
+		if len(branches) == 0 {
+			return &branch{}
+		}
+		return branches[len(branches)-1]
+	}
+	endCommand := func() {
+		p.vars = append(p.vars, pending...)
+		pending = pending[:0]
+		cmdStart, export = true, false
+		if call != nil {
+			p.call(call, commented)
+			call = nil
+		}
+	}
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			endCommand()
+			return nil
+		}
+		if heredoc {
+			heredoc = false
+			if tok.kind == wordToken {
+				// <<-EOF allows indenting the delimiter, which we do anyway
+				delim, _ := unquote(strings.TrimPrefix(tok.text, "-"))
+				l.heredocs = append(l.heredocs, delim)
+			}
+			continue
+		}
+		if listStart < 0 && tok.kind == wordToken {
+			listStart = tok.span.start
+		}
+		for _, v := range tok.defaults {
+			v.Conditional = depth > 0 || andOr
+			v.conds = conditions(branches, andOrCond)
+			p.add(v, commented)
+		}
+		switch tok.kind {
+		case newlineToken:
+			endCommand()
+			andOr, andOrCond, listStart = false, nil, -1
+		case commentToken:
+			if !commented {
+				p.template(tok)
+			}
+		case opToken:
+			switch tok.text {
+			case "<<":
+				heredoc = true
+			case "&&", "||":
+				endCommand()
+				andOr = true
+				andOrCond = &condition{unknown: true}
+				if listStart >= 0 {
+					test := strings.TrimSpace(p.text(span{listStart, tok.span.start}))
+					andOrCond = &condition{test: test, negate: tok.text == "||", at: listStart}
+				}
+			case ";", "&", ";;":
+				endCommand()
+				andOr, andOrCond, listStart = false, nil, -1
+			case "|", "(", ")":
+				endCommand()
+			}
+		case wordToken:
+			if call != nil {
+				call.words = append(call.words, tok)
+				continue
+			}
+			if cmdStart && !export {
+				if d, ok := reservedWords[tok.text]; ok && len(pending) == 0 {
+					depth += d
+					switch {
+					case tok.text == "if":
+						branches = append(branches, &branch{known: true})
+						testStart = tok.span.end
+					case tok.text == "elif" || tok.text == "else":
+						if b := top(); b.test != nil {
+							b.prior, b.test = append(b.prior, *b.test), nil
+						}
+						if tok.text == "elif" {
+							testStart = tok.span.end
+						}
+					case tok.text == "then" && testStart >= 0:
+						test := strings.TrimSpace(p.text(span{testStart, tok.span.start}))
+						test = strings.TrimSpace(strings.TrimSuffix(test, ";"))
+						top().test = &condition{test: test, at: testStart}
+						testStart = -1
+					case d > 0:
+						branches = append(branches, &branch{})
+					case d < 0 && len(branches) > 0:
+						branches = branches[:len(branches)-1]
+					}
+					listStart = -1
+					// for NAME in ... and case WORD in ... are not commands
+					cmdStart = tok.text != "for" && tok.text != "case"
+					continue
+				}
+			}
+			m := assignmentWord.FindStringSubmatch(tok.text)
+			switch {
+			case m != nil && (cmdStart || export):
+				value := span{tok.span.start + len(m[0]), tok.span.end}
+				v := &Var{Name: m[1], stmt: tok.span, value: value}
+				v.val, v.quote = unquote(tok.text[len(m[0]):])
+				v.Exported = export
+				v.Conditional = depth > 0 || andOr
+				v.conds = conditions(branches, andOrCond)
+				p.add(v, commented)
+				pending = append(pending, v)
+			case export && nameWord.MatchString(tok.text):
+				p.exported[tok.text] = true
+				for _, v := range p.vars {
+					if v.Name == tok.text {
+						v.Exported = true
+					}
+				}
+			case export:
+				// an option of export
+			case cmdStart && tok.text == "export":
+				export = true
+			case cmdStart:
+				// a command, assignments before it are not variables of the file
+				pending = pending[:0]
+				cmdStart = false
+				if _, ok := hadoop3Functions[tok.text]; ok {
+					call = &shellCall{name: tok.text, start: tok.span.start, conditional: depth > 0 || andOr, conds: conditions(branches, andOrCond)}
+				}
+			}
+		}
+	}
+}
+
+// add sets the fields of v which depend on the file and on what was parsed so far
+func (p *shellParser) add(v *Var, commented bool) {
+	v.Source = p.path
+	v.line = strings.Count(p.src[:v.stmt.start-p.base], "\n")
+	if p.exported[v.Name] {
+		v.Exported = true
+	}
+	if v.Exported && !commented {
+		p.exported[v.Name] = true
+	}
+	if v.IfUnset {
+		v.val, v.quote = unquote(p.src[v.value.start-p.base : v.value.end-p.base])
+	}
+	if commented {
+		v.Comment, v.val, v.commented = v.val, "", true
+	}
+	// other assignments are added once we know they are not just for the environment of a command
+	if v.IfUnset {
+		p.vars = append(p.vars, v)
+	}
+}
+
+// shellCall is a call of a hadoop 3 function which changes a variable
+type shellCall struct {
+	name  string
+	words []*token
+	start int
+	// whether the call may not run, as of its first word
+	conditional bool
+	conds       []condition
+}
+
+// call adds the variable a call changes, if it's a call we understand
+func (p *shellParser) call(c *shellCall, commented bool) {
+	name, words := hadoop3Functions[c.name], c.words
+	param := ""
+	if name == "" {
+		// hadoop_add_param NAME CHECK VALUE
+		if len(words) < 3 || !nameWord.MatchString(words[0].text) {
+			return
+		}
+		name = words[0].text
+		param, _ = unquote(words[1].text)
+		words = words[2:]
+	} else if len(words) > 1 {
+		// hadoop_add_classpath PATH before
+		param, _ = unquote(words[1].text)
+	}
+	if len(words) == 0 {
+		return
+	}
+	v := &Var{Name: name, Func: c.name, param: param, Conditional: c.conditional, conds: c.conds}
+	v.stmt = span{c.start, c.words[len(c.words)-1].span.end}
+	v.value = words[0].span
+	v.val, v.quote = unquote(words[0].text)
+	p.add(v, commented)
+	p.vars = append(p.vars, v)
+}
+
+var templateLine = regexp.MustCompile(`^#\s*export\s`)
+
+// template parses a comment of the form "# export NAME=value", which hadoop's env files use to
+// show variables one may want to set
+func (p *shellParser) template(tok *token) {
+	if !templateLine.MatchString(tok.text) {
+		return
+	}
+	// comments are often prose, which needs not be valid shell
+	sub := &shellParser{path: p.path, src: p.src, base: p.base, exported: map[string]bool{}}
+	body := tok.text[1:]
+	if err := sub.parse(&lexer{src: body, base: tok.span.start + 1}, true); err != nil {
+		return
+	}
+	for _, v := range sub.vars {
+		if v.Exported {
+			// uncommenting replaces the comment up to the end of the assignment
+			v.stmt.start = tok.span.start
+			p.vars = append(p.vars, v)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package hadoopconf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+const shellEnv = `# The java implementation to use.
+JAVA_HOME=/usr/java
+export JAVA_HOME HADOOP_LOG_DIR
+HADOOP_LOG_DIR='/var/log/hadoop dir'
+export HADOOP_OPTS="-Dfoo=bar \
+  -Dbaz=qux"
+export HADOOP_CONF_DIR=${HADOOP_CONF_DIR:-"/etc/hadoop"} HADOOP_PREFIX=/opt/hadoop
+: ${HADOOP_PID_DIR:=/var/run/hadoop}
+if [ "$HADOOP_HEAPSIZE" = "" ]; then
+  export HADOOP_HEAPSIZE=1000 # in MB
+fi
+[ -n "$JSVC_HOME" ] && export JSVC_HOME=/usr/bin
+LANG=C sort /dev/null
+cat > /dev/null <<EOF
+export NOT_A_VAR=1
+EOF
+# export HADOOP_NICENESS=10
+# export isn't always a template
+`
+
+func TestParseShell(t *testing.T) {
+	Terst(t)
+	vars, err := parseShell("hadoop-env.sh", shellEnv, 0)
+	FailOnErr(err)
+	env := &Env{Path: "hadoop-env.sh", Vars: vars}
+	Is(strings.Join(env.Keys(), " "), "JAVA_HOME HADOOP_LOG_DIR HADOOP_OPTS HADOOP_CONF_DIR HADOOP_PREFIX HADOOP_PID_DIR HADOOP_HEAPSIZE JSVC_HOME HADOOP_NICENESS")
+
+	v := env.Get("JAVA_HOME")
+	Is(v.GetVal(), "/usr/java")
+	Is(v.Exported, true)
+	Is(v.line, 1)
+	v = env.Get("HADOOP_LOG_DIR")
+	Is(v.GetVal(), "/var/log/hadoop dir")
+	// exported before it's assigned
+	Is(v.Exported, true)
+	Is(env.Get("HADOOP_OPTS").GetVal(), "-Dfoo=bar   -Dbaz=qux")
+	Is(env.Get("HADOOP_CONF_DIR").GetVal(), `${HADOOP_CONF_DIR:-"/etc/hadoop"}`)
+	Is(env.Get("HADOOP_PREFIX").GetVal(), "/opt/hadoop")
+	v = env.Get("HADOOP_PID_DIR")
+	Is(v.GetVal(), "/var/run/hadoop")
+	Is(v.IfUnset, true)
+	v = env.Get("HADOOP_HEAPSIZE")
+	Is(v.GetVal(), "1000")
+	Is(v.Conditional, true)
+	Is(env.Get("JSVC_HOME").Conditional, true)
+	Is(env.Get("LANG"), (*Var)(nil))
+	Is(env.Get("NOT_A_VAR"), (*Var)(nil))
+	v = env.Get("HADOOP_NICENESS")
+	Is(v.commented, true)
+	Is(v.Comment, "10")
+	Is(v.GetVal(), "")
+
+	_, err = parseShell("hadoop-env.sh", "export A=\"unterminated\n", 0)
+	if IsNot(err, nil) {
+		Is(err.Error(), `hadoop-env.sh: line 1: unterminated "`)
+	}
+}
+
+func TestSaveShellSpans(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hadoop-env.sh": shellEnv})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hadoop-env.sh")
+	env, err := NewEnvFromFile(path)
+	FailOnErr(err)
+	env.Get("HADOOP_LOG_DIR").SetVal("/logs")
+	env.Get("HADOOP_OPTS").Append("-Xmx1g")
+	env.Get("HADOOP_PREFIX").SetVal("/opt/hadoop 2")
+	env.Get("HADOOP_PID_DIR").SetVal("/run")
+	env.Get("HADOOP_NICENESS").SetVal("5")
+	FailOnErr(env.Save(false))
+	expected := strings.NewReplacer(
+		`HADOOP_LOG_DIR='/var/log/hadoop dir'`, `HADOOP_LOG_DIR='/logs'`,
+		"\"-Dfoo=bar \\\n  -Dbaz=qux\"", `"-Dfoo=bar   -Dbaz=qux -Xmx1g"`,
+		"HADOOP_PREFIX=/opt/hadoop\n", "HADOOP_PREFIX=\"/opt/hadoop 2\"\n",
+		"${HADOOP_PID_DIR:=/var/run/hadoop}", "${HADOOP_PID_DIR:=/run}",
+		"# export HADOOP_NICENESS=10", "export HADOOP_NICENESS=5",
+	).Replace(shellEnv)
+	Is(readFile(path), expected)
+	// the positions are those of the saved file
+	Is(env.Get("HADOOP_NICENESS").commented, false)
+	env.Get("HADOOP_HEAPSIZE").SetVal("2000")
+	FailOnErr(env.Save(false))
+	Is(readFile(path), strings.Replace(expected, "HADOOP_HEAPSIZE=1000 #", "HADOOP_HEAPSIZE=2000 #", 1))
+}
+
+func TestParseYarnEnv(t *testing.T) {
+	Terst(t)
+	env, err := NewEnvFromFile(filepath.Join(tempDir, hadoop2, "etc", "hadoop", "yarn-env.sh"))
+	FailOnErr(err)
+	// the unconditional assignment is the one in effect
+	v := env.Get("JAVA_HEAP_MAX")
+	Is(v.GetVal(), "-Xmx1000m")
+	Is(v.Exported, false)
+	assignments := []*Var{}
+	for _, v := range env.Vars {
+		if v.Name == "JAVA_HEAP_MAX" {
+			assignments = append(assignments, v)
+		}
+	}
+	if Is(len(assignments), 2) {
+		Is(assignments[1].GetVal(), `"-Xmx""$YARN_HEAPSIZE""m"`)
+		Is(assignments[1].Conditional, true)
+	}
+	Is(env.Get("YARN_OPTS").GetVal(), "$YARN_OPTS -Dhadoop.log.dir=$YARN_LOG_DIR")
+	Is(env.Get("YARN_CONF_DIR").GetVal(), "${YARN_CONF_DIR:-$HADOOP_YARN_HOME/conf}")
+	Is(env.Get("YARN_RESOURCEMANAGER_HEAPSIZE").Comment, "1000")
+}
+
+func TestQuoteValue(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hadoop-env.sh": `export HADOOP_OPTS="$HADOOP_OPTS -Dfoo=bar"
+export HADOOP_LOG_DIR='/var/log/hadoop'
+export HADOOP_IDENT_STRING=hadoop
+export HADOOP_PID_DIR=/run
+export HADOOP_SECURE_DN_USER=hdfs
+export HADOOP_CLASSPATH="$HADOOP_CLASSPATH"
+`})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hadoop-env.sh")
+	// the values as the shell has them once the file runs
+	expected := map[string]string{
+		"HADOOP_OPTS":           `-Dbase -XX:OnOutOfMemoryError="kill -9 %p"`,
+		"HADOOP_LOG_DIR":        `/var/log/it's here`,
+		"HADOOP_IDENT_STRING":   "say \"hi\", costs $5 `not run`",
+		"HADOOP_PID_DIR":        `C:\run\`,
+		"HADOOP_SECURE_DN_USER": `"hdfs"`,
+		"HADOOP_CLASSPATH":      `/base:/extra`,
+	}
+	env, err := NewEnvFromFile(path)
+	FailOnErr(err)
+	env.Get("HADOOP_OPTS").SetVal(`$HADOOP_OPTS -XX:OnOutOfMemoryError="kill -9 %p"`)
+	env.Get("HADOOP_LOG_DIR").SetVal(`/var/log/it's here`)
+	// not valid within double quotes, taken literally
+	env.Get("HADOOP_IDENT_STRING").SetVal("say \"hi\", costs $5 `not run`")
+	env.Get("HADOOP_PID_DIR").SetVal(`C:\run\`)
+	env.Get("HADOOP_SECURE_DN_USER").SetVal(`"hdfs"`)
+	// valid within double quotes, kept as is
+	env.Get("HADOOP_CLASSPATH").SetVal(`${HADOOP_CLASSPATH}:/extra`)
+	FailOnErr(env.Save(false))
+	Is(readFile(path), `export HADOOP_OPTS="$HADOOP_OPTS -XX:OnOutOfMemoryError=\"kill -9 %p\""
+export HADOOP_LOG_DIR='/var/log/it'\''s here'
+`+"export HADOOP_IDENT_STRING=\"say \\\"hi\\\", costs \\$5 \\`not run\\`\"\n"+`export HADOOP_PID_DIR="C:\\run\\"
+export HADOOP_SECURE_DN_USER="\"hdfs\""
+export HADOOP_CLASSPATH="${HADOOP_CLASSPATH}:/extra"
+`)
+	env, err = NewEnvFromFile(path)
+	FailOnErr(err)
+	base := Environment{"HADOOP_OPTS": "-Dbase", "HADOOP_CLASSPATH": "/base"}
+	for name, value := range expected {
+		Is(base.expand(env.Get(name).word()), value)
+	}
+}
@@ -0,0 +1,306 @@
+package hadoopconf
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// rename is os.Rename, tests replace it to fail on purpose
+var rename = os.Rename
+
+// geteuid is os.Geteuid, tests replace it to save as another user
+var geteuid = os.Geteuid
+
+// transaction writes several files, all or nothing. Files are staged next to their
+// targets, so that renaming them over the targets is atomic, and synced to disk before
+// any target is replaced. The targets exist throughout, readers see either the old file
+// or the new one. If replacing a target fails, all targets replaced so far are put back.
+type transaction struct {
+	// suffix for backups of the replaced files, no backups are kept if it's empty
+	backupSuffix string
+	staged       []*stagedFile
+}
+
+type stagedFile struct {
+	path    string
+	tmp     string
+	content []byte
+	// the file as it was read, nil if it's written regardless of changes since
+	stamp *fileStamp
+	// called with the content written once the file is in place, fails if it can't be read back
+	done func(written []byte) error
+	// where the replaced file is kept, empty if there was none
+	aside string
+	// the target is overwritten rather than replaced, orig is its content before, and backup
+	// where it was copied, empty if it wasn't
+	inPlace bool
+	orig    []byte
+	backup  string
+}
+
+func newTransaction(backupSuffix string) *transaction {
+	return &transaction{backupSuffix: backupSuffix}
+}
+
+// Write stages content to be written to path on Commit. If stamp is not nil, and the file
+// changed since, Commit fails or merges according to OnConflict, the stamp is then updated.
+// done, if not nil, is called after a successful commit, Commit returns its error. The staged file gets the mode and
+// ownership of the file it replaces, or those of the NewFiles policy for new files.
+// Only root can give a file someone else's ownership, so other users overwrite the files of
+// others in place, as they did before files were staged.
+func (tx *transaction) Write(path string, stamp *fileStamp, content []byte, done func(written []byte) error) error {
+	mode, uid, gid := NewFiles.Mode, NewFiles.Uid, NewFiles.Gid
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		uid, gid = fileOwner(info)
+		if euid := geteuid(); euid != 0 && uid != -1 && uid != euid {
+			tx.staged = append(tx.staged, &stagedFile{path: path, content: content, stamp: stamp, done: done, inPlace: true})
+			return nil
+		}
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	tx.staged = append(tx.staged, &stagedFile{path: path, tmp: f.Name(), content: content, stamp: stamp, done: done})
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := chown(f, uid, gid); err != nil {
+		f.Close()
+		return errors.New("cannot give " + path + " its owner: " + err.Error())
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// chown changes the owner of f, unless it already has it. -1 leaves the owner, or group, as is.
+func chown(f *os.File, uid, gid int) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if curUid, curGid := fileOwner(info); curUid == uid || uid == -1 {
+		if curGid == gid || gid == -1 {
+			return nil
+		}
+	}
+	return f.Chown(uid, gid)
+}
+
+// Abort removes the staged files, targets are left untouched
+func (tx *transaction) Abort() {
+	for _, s := range tx.staged {
+		if !s.inPlace {
+			os.Remove(s.tmp)
+		}
+	}
+	tx.staged = nil
+}
+
+// Commit replaces all targets by their staged files. The directories of the targets are
+// locked meanwhile, so that concurrent commits don't miss each other's changes.
+func (tx *transaction) Commit() error {
+	dirs := []string{}
+	for _, s := range tx.staged {
+		dirs = append(dirs, filepath.Dir(s.path))
+	}
+	// always locked in the same order, so that two commits don't wait for each other
+	dirs = unionKeys(dirs, nil)
+	for _, dir := range dirs {
+		unlock, err := lockDir(dir)
+		if err != nil {
+			tx.Abort()
+			return err
+		}
+		defer unlock()
+	}
+	for _, s := range tx.staged {
+		if err := s.checkStamp(); err != nil {
+			tx.Abort()
+			return err
+		}
+	}
+	// targets are kept aside, so that they can be put back, and replaced by a rename, so
+	// that a daemon starting meanwhile never misses them
+	aside := tx.backupSuffix
+	if aside == "" {
+		aside = ".hadoopconf-rollback"
+	}
+	for i, s := range tx.staged {
+		if s.inPlace {
+			if err := s.overwrite(tx.backupSuffix); err != nil {
+				return tx.rollback(i+1, err)
+			}
+			continue
+		}
+		if err := keepAside(s.path, s.path+aside); err == nil {
+			s.aside = s.path + aside
+		} else if !os.IsNotExist(err) {
+			return tx.rollback(i, err)
+		}
+		if err := rename(s.tmp, s.path); err != nil {
+			return tx.rollback(i+1, err)
+		}
+	}
+	unread := []string{}
+	for _, s := range tx.staged {
+		if tx.backupSuffix == "" && s.aside != "" {
+			os.Remove(s.aside)
+		}
+		if s.stamp != nil {
+			s.stamp.read(s.path)
+		}
+		if s.done != nil {
+			if err := s.done(s.content); err != nil {
+				unread = append(unread, err.Error())
+			}
+		}
+	}
+	tx.staged = nil
+	for _, dir := range dirs {
+		syncDir(dir)
+	}
+	if len(unread) > 0 {
+		return errors.New("saved, but cannot read back " + strings.Join(unread, ", "))
+	}
+	return nil
+}
+
+// keepAside links path to aside, or copies it where hard links aren't supported, leaving
+// path in place
+func keepAside(path, aside string) error {
+	if err := os.Remove(aside); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err := os.Link(path, aside)
+	if err == nil || os.IsNotExist(err) {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(aside, content, info.Mode().Perm())
+}
+
+// checkStamp makes sure the staged file does not lose changes made to the target since it was
+// read, by failing, or by staging a merge
+func (s *stagedFile) checkStamp() error {
+	if s.stamp == nil {
+		return nil
+	}
+	current, changed, err := s.stamp.changed(s.path)
+	if err != nil || !changed {
+		return err
+	}
+	content, err := s.stamp.resolve(s.path, s.content, current)
+	if err != nil || bytes.Equal(content, s.content) {
+		return err
+	}
+	s.content = content
+	if s.inPlace {
+		return nil
+	}
+	return writeOver(s.tmp, content)
+}
+
+// writeOver writes content over the file in path, which keeps its inode, mode and owner
+func writeOver(path string, content []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// overwrite writes the staged content over the target, after copying it to the target with
+// backupSuffix, unless it's empty
+func (s *stagedFile) overwrite(backupSuffix string) error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	orig, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	if backupSuffix != "" {
+		if err := ioutil.WriteFile(s.path+backupSuffix, orig, info.Mode().Perm()); err != nil {
+			return err
+		}
+		s.backup = s.path + backupSuffix
+	}
+	s.orig = orig
+	return writeOver(s.path, s.content)
+}
+
+// rollback puts back the targets of the first n staged files, and removes all staged files
+func (tx *transaction) rollback(n int, cause error) error {
+	failed := []string{}
+	for _, s := range tx.staged[:n] {
+		if s.inPlace {
+			if s.orig == nil {
+				continue
+			}
+			if err := writeOver(s.path, s.orig); err != nil && s.backup != "" {
+				failed = append(failed, s.path+" is in "+s.backup)
+			} else if err != nil {
+				failed = append(failed, s.path+" could not be written back")
+			} else if s.backup != "" {
+				os.Remove(s.backup)
+			}
+		} else if s.aside == "" {
+			// there was no file before
+			os.Remove(s.path)
+		} else if sameFile(s.aside, s.path) {
+			// the target was not replaced, renaming a link over the same file does nothing
+			os.Remove(s.aside)
+		} else if err := rename(s.aside, s.path); err != nil {
+			failed = append(failed, s.path+" is in "+s.aside)
+		}
+	}
+	tx.Abort()
+	if len(failed) > 0 {
+		return errors.New(cause.Error() + ", and could not restore all files: " + strings.Join(failed, ", "))
+	}
+	return cause
+}
+
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// syncDir makes renames in dir durable, errors are ignored as not all systems support it
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
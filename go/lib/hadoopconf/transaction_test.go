@@ -0,0 +1,239 @@
+package hadoopconf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestTransactionCommit(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"core-site.xml": "core 0", "hdfs-site.xml": "hdfs 0"})
+	defer os.RemoveAll(dir)
+	core, hdfs, yarn := filepath.Join(dir, "core-site.xml"), filepath.Join(dir, "hdfs-site.xml"), filepath.Join(dir, "yarn-site.xml")
+	FailOnErr(os.Chmod(hdfs, 0600))
+	done := 0
+	tx := newTransaction(".bak")
+	count := func([]byte) error {
+		done++
+		return nil
+	}
+	FailOnErr(tx.Write(core, nil, []byte("core 1"), count))
+	FailOnErr(tx.Write(hdfs, nil, []byte("hdfs 1"), count))
+	FailOnErr(tx.Write(yarn, nil, []byte("yarn 1"), nil))
+	// nothing is replaced before commit
+	Is(readFile(core), "core 0")
+	FailOnErr(tx.Commit())
+	Is(done, 2)
+	Is(readFile(core), "core 1")
+	Is(readFile(hdfs), "hdfs 1")
+	Is(readFile(yarn), "yarn 1")
+	Is(readFile(core+".bak"), "core 0")
+	Is(readFile(hdfs+".bak"), "hdfs 0")
+	info, err := os.Stat(hdfs)
+	FailOnErr(err)
+	Is(info.Mode().Perm(), os.FileMode(0600))
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	Is(len(matches), 5)
+}
+
+func TestTransactionRollback(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"core-site.xml": "core 0", "hdfs-site.xml": "hdfs 0"})
+	defer os.RemoveAll(dir)
+	core, hdfs, yarn := filepath.Join(dir, "core-site.xml"), filepath.Join(dir, "hdfs-site.xml"), filepath.Join(dir, "yarn-site.xml")
+	// the staged hdfs-site.xml cannot be put in place
+	defer func() { rename = os.Rename }()
+	rename = func(from, to string) error {
+		if to == hdfs && strings.HasPrefix(filepath.Base(from), ".hdfs-site.xml.") {
+			return errors.New("disk on fire")
+		}
+		return os.Rename(from, to)
+	}
+	tx := newTransaction("")
+	FailOnErr(tx.Write(core, nil, []byte("core 1"), nil))
+	FailOnErr(tx.Write(yarn, nil, []byte("yarn 1"), nil))
+	FailOnErr(tx.Write(hdfs, nil, []byte("hdfs 1"), nil))
+	err := tx.Commit()
+	if IsNot(err, nil) {
+		Is(err.Error(), "disk on fire")
+	}
+	Is(readFile(core), "core 0")
+	Is(readFile(hdfs), "hdfs 0")
+	// no staged, aside or new files are left behind
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	Is(len(matches), 2)
+	matches, _ = filepath.Glob(filepath.Join(dir, ".*"))
+	Is(len(matches), 0)
+}
+
+func TestTransactionTargetsExist(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"core-site.xml": "core 0", "hdfs-site.xml": "hdfs 0"})
+	defer os.RemoveAll(dir)
+	core, hdfs := filepath.Join(dir, "core-site.xml"), filepath.Join(dir, "hdfs-site.xml")
+	// a daemon starting at any point of the commit finds all the files
+	missing := []string{}
+	renames := 0
+	defer func() { rename = os.Rename }()
+	rename = func(from, to string) error {
+		renames++
+		for _, path := range []string{core, hdfs} {
+			if _, err := os.Stat(path); err != nil {
+				missing = append(missing, path)
+			}
+		}
+		err := os.Rename(from, to)
+		for _, path := range []string{core, hdfs} {
+			if _, err := os.Stat(path); err != nil {
+				missing = append(missing, path)
+			}
+		}
+		return err
+	}
+	for _, suffix := range []string{"", ".bak"} {
+		tx := newTransaction(suffix)
+		FailOnErr(tx.Write(core, nil, []byte("core 1"+suffix), nil))
+		FailOnErr(tx.Write(hdfs, nil, []byte("hdfs 1"+suffix), nil))
+		FailOnErr(tx.Commit())
+	}
+	Is(renames, 4)
+	Is(len(missing), 0)
+	Is(readFile(core), "core 1.bak")
+	Is(readFile(core+".bak"), "core 1")
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+	Is(len(matches), 4)
+}
+
+func TestSaveEnvNextToTarget(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hadoop-env.sh": "export JAVA_HOME=/usr/java\n"})
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hadoop-env.sh")
+	// fail any rename which isn't within dir, as a rename across file systems would
+	defer func() { rename = os.Rename }()
+	rename = func(from, to string) error {
+		if filepath.Dir(from) != dir || filepath.Dir(to) != dir {
+			return errors.New("cross-device link")
+		}
+		return os.Rename(from, to)
+	}
+	env, err := NewEnvFromFile(path)
+	FailOnErr(err)
+	env.Get("JAVA_HOME").SetVal("/opt/java")
+	FailOnErr(env.Save(false))
+	Is(readFile(path), "export JAVA_HOME=/opt/java\n")
+}
+
+func TestTransactionOwnership(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"core-site.xml": "core 0"})
+	defer os.RemoveAll(dir)
+	core, yarn := filepath.Join(dir, "core-site.xml"), filepath.Join(dir, "yarn-site.xml")
+	defer func(policy FilePolicy) { NewFiles = policy }(NewFiles)
+	NewFiles = FilePolicy{Mode: 0640, Uid: -1, Gid: -1}
+	// only root can give files away
+	root := os.Getuid() == 0
+	if root {
+		FailOnErr(os.Chown(core, 1, 2))
+		NewFiles.Uid, NewFiles.Gid = 3, 4
+	}
+	tx := newTransaction("")
+	FailOnErr(tx.Write(core, nil, []byte("core 1"), nil))
+	FailOnErr(tx.Write(yarn, nil, []byte("yarn 1"), nil))
+	FailOnErr(tx.Commit())
+	info, err := os.Stat(yarn)
+	FailOnErr(err)
+	Is(info.Mode().Perm(), os.FileMode(0640))
+	if root {
+		uid, gid := fileOwner(info)
+		Is(uid, 3)
+		Is(gid, 4)
+		info, err = os.Stat(core)
+		FailOnErr(err)
+		uid, gid = fileOwner(info)
+		Is(uid, 1)
+		Is(gid, 2)
+	}
+}
+
+func TestTransactionNotOwner(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{"hdfs-site.xml": "hdfs 0"})
+	defer os.RemoveAll(dir)
+	hdfs := filepath.Join(dir, "hdfs-site.xml")
+	// we save as a user who is neither root nor the owner of the files, such as an operator
+	// who may write the files of hdfs
+	defer func() { geteuid = os.Geteuid }()
+	geteuid = func() int { return os.Geteuid() + 1 }
+	before, err := os.Stat(hdfs)
+	FailOnErr(err)
+	tx := newTransaction(".bak")
+	FailOnErr(tx.Write(hdfs, nil, []byte("hdfs 1"), nil))
+	FailOnErr(tx.Commit())
+	Is(readFile(hdfs), "hdfs 1")
+	Is(readFile(hdfs+".bak"), "hdfs 0")
+	after, err := os.Stat(hdfs)
+	FailOnErr(err)
+	Is(os.SameFile(before, after), true)
+	matches, _ := filepath.Glob(filepath.Join(dir, ".*"))
+	Is(len(matches), 0)
+
+	// and put back when the commit fails
+	defer func() { rename = os.Rename }()
+	rename = func(from, to string) error {
+		return errors.New("disk on fire")
+	}
+	tx = newTransaction(".bak2")
+	FailOnErr(tx.Write(hdfs, nil, []byte("hdfs 2"), nil))
+	FailOnErr(tx.Write(filepath.Join(dir, "yarn-site.xml"), nil, []byte("yarn 1"), nil))
+	IsNot(tx.Commit(), nil)
+	Is(readFile(hdfs), "hdfs 1")
+	_, err = os.Stat(hdfs + ".bak2")
+	Is(os.IsNotExist(err), true)
+}
+
+func TestParseOwner(t *testing.T) {
+	Terst(t)
+	uid, gid, err := ParseOwner("12:34")
+	FailOnErr(err)
+	Is(uid, 12)
+	Is(gid, 34)
+	uid, gid, err = ParseOwner(":34")
+	FailOnErr(err)
+	Is(uid, -1)
+	Is(gid, 34)
+	uid, gid, err = ParseOwner("root")
+	FailOnErr(err)
+	Is(uid, 0)
+	Is(gid, -1)
+	_, _, err = ParseOwner("no-such-user-hopefully:root")
+	IsNot(err, nil)
+}
+
+func TestLockDir(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{})
+	defer os.RemoveAll(dir)
+	unlock, err := lockDir(dir)
+	FailOnErr(err)
+	locked := make(chan bool)
+	go func() {
+		unlock, err := lockDir(dir)
+		FailOnErr(err)
+		unlock()
+		locked <- true
+	}()
+	select {
+	case <-locked:
+		Is("second lock taken", "while the first is held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
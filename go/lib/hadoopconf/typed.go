@@ -0,0 +1,328 @@
+package hadoopconf
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The typed getters below follow org.apache.hadoop.conf.Configuration: values are
+// trimmed and their ${...} references expanded, and a missing or empty value yields
+// the given default. A value which cannot be parsed is an error naming the file it
+// came from.
+
+// typedGet returns the trimmed, expanded value of key, ok is false if it's unset
+func (c *HadoopConf) typedGet(key string) (value string, src Source, ok bool, err error) {
+	value, src, err = c.ResolvedSourceGet(key)
+	if err != nil {
+		return "", src, false, errors.New(src.Source + ": " + key + ": " + err.Error())
+	}
+	value = strings.TrimSpace(value)
+	return value, src, src != NoSource && value != "", nil
+}
+
+func typeError(key, value string, src Source, what string) error {
+	return errors.New(src.Source + ": " + key + "=" + strconv.Quote(value) + " is not " + what)
+}
+
+// parseInt parses a decimal number, or a hexadecimal one prefixed with 0x, like hadoop's getLong
+func parseInt(s string) (int64, error) {
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+		v, err := strconv.ParseInt(digits[2:], 16, 64)
+		if neg {
+			v = -v
+		}
+		return v, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// GetInt returns key as an integer, or dflt if it's not set
+func (c *HadoopConf) GetInt(key string, dflt int64) (int64, error) {
+	v, src, ok, err := c.typedGet(key)
+	if !ok || err != nil {
+		return dflt, err
+	}
+	i, err := parseInt(v)
+	if err != nil {
+		return dflt, typeError(key, v, src, "an integer")
+	}
+	return i, nil
+}
+
+// GetBool returns key as a boolean, which must be "true" or "false" in any case, or dflt if it's not set
+func (c *HadoopConf) GetBool(key string, dflt bool) (bool, error) {
+	v, src, ok, err := c.typedGet(key)
+	if !ok || err != nil {
+		return dflt, err
+	}
+	switch strings.ToLower(v) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return dflt, typeError(key, v, src, "a boolean (true or false)")
+}
+
+// binaryPrefixes are the size suffixes hadoop's StringUtils.TraditionalBinaryPrefix accepts
+var binaryPrefixes = []struct {
+	suffix string
+	value  int64
+}{
+	{"k", 1 << 10},
+	{"m", 1 << 20},
+	{"g", 1 << 30},
+	{"t", 1 << 40},
+	{"p", 1 << 50},
+	{"e", 1 << 60},
+}
+
+// ParseBytes parses a size such as 64m, the suffixes k, m, g, t, p and e are
+// powers of 1024 and are case insensitive
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, errors.New("empty size")
+	}
+	last := strings.ToLower(s[len(s)-1:])
+	if last[0] >= '0' && last[0] <= '9' {
+		return strconv.ParseInt(s, 10, 64)
+	}
+	for _, prefix := range binaryPrefixes {
+		if prefix.suffix != last {
+			continue
+		}
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if n > (1<<63-1)/prefix.value || n < (-1<<63)/prefix.value {
+			return 0, errors.New(s + " overflows a 64 bit integer")
+		}
+		return n * prefix.value, nil
+	}
+	return 0, errors.New("invalid size prefix '" + last + "' in '" + s + "', allowed prefixes are k, m, g, t, p, e (case insensitive)")
+}
+
+// FormatBytes formats n with the largest suffix that represents it exactly
+func FormatBytes(n int64) string {
+	for i := len(binaryPrefixes) - 1; i >= 0; i-- {
+		if p := binaryPrefixes[i]; n != 0 && n%p.value == 0 {
+			return strconv.FormatInt(n/p.value, 10) + p.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// GetBytes returns key as a size in bytes, or dflt if it's not set. See ParseBytes for the format.
+func (c *HadoopConf) GetBytes(key string, dflt int64) (int64, error) {
+	v, src, ok, err := c.typedGet(key)
+	if !ok || err != nil {
+		return dflt, err
+	}
+	n, err := ParseBytes(v)
+	if err != nil {
+		return dflt, typeError(key, v, src, "a size: "+err.Error())
+	}
+	return n, nil
+}
+
+// timeUnits are the suffixes of hadoop's Configuration.ParsedTimeDuration, in the order
+// hadoop tries them, so that 10ms is not taken for minutes
+var timeUnits = []struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"ns", time.Nanosecond},
+	{"us", time.Microsecond},
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+}
+
+// ParseDuration parses a duration such as 30s, the suffixes are ns, us, ms, s, m, h and d.
+// A number without a suffix is in unit, which is what the property is documented in.
+func ParseDuration(s string, unit time.Duration) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	value := s
+	for _, u := range timeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			unit = u.unit
+			s = strings.TrimSuffix(s, u.suffix)
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > math.MaxInt64/int64(unit) || n < math.MinInt64/int64(unit) {
+		return 0, errors.New(value + " overflows a 64 bit duration")
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// FormatDuration formats d with the largest suffix that represents it exactly
+func FormatDuration(d time.Duration) string {
+	for i := len(timeUnits) - 1; i >= 0; i-- {
+		if u := timeUnits[i]; d != 0 && d%u.unit == 0 {
+			return strconv.FormatInt(int64(d/u.unit), 10) + u.suffix
+		}
+	}
+	return "0"
+}
+
+// GetDuration returns key as a duration, or dflt if it's not set.
+// Values without a suffix are in unit. See ParseDuration for the format.
+func (c *HadoopConf) GetDuration(key string, dflt time.Duration, unit time.Duration) (time.Duration, error) {
+	v, src, ok, err := c.typedGet(key)
+	if !ok || err != nil {
+		return dflt, err
+	}
+	d, err := ParseDuration(v, unit)
+	if err != nil {
+		return dflt, typeError(key, v, src, "a duration")
+	}
+	return d, nil
+}
+
+// GetStrings returns the comma separated values of key, trimmed and without empty
+// values, like hadoop's getTrimmedStrings. dflt is returned if it's not set.
+func (c *HadoopConf) GetStrings(key string, dflt ...string) ([]string, error) {
+	v, _, ok, err := c.typedGet(key)
+	if !ok || err != nil {
+		return dflt, err
+	}
+	values := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values, nil
+}
+
+// ParseSocketAddr parses host:port, host alone or a URI such as hdfs://host:port/,
+// like hadoop's NetUtils.createSocketAddr. dfltPort is used when no port is given.
+func ParseSocketAddr(s string, dfltPort int) (host string, port int, err error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return "", 0, err
+		}
+		s = u.Host
+	}
+	host, portString, err := net.SplitHostPort(s)
+	if err != nil {
+		// no port
+		host, portString = strings.Trim(s, "[]"), ""
+	}
+	if host == "" {
+		return "", 0, errors.New("no host in " + s)
+	}
+	if portString == "" {
+		if dfltPort < 0 {
+			return "", 0, errors.New("no port in " + s)
+		}
+		return host, dfltPort, nil
+	}
+	port, err = strconv.Atoi(portString)
+	if err != nil || port < 0 || port > 65535 {
+		return "", 0, errors.New("invalid port " + portString)
+	}
+	return host, port, nil
+}
+
+// GetSocketAddr returns the host and port of key, or of dflt if it's not set.
+// Pass a negative dfltPort to require an explicit port. See ParseSocketAddr for the format.
+func (c *HadoopConf) GetSocketAddr(key string, dflt string, dfltPort int) (host string, port int, err error) {
+	v, src, ok, err := c.typedGet(key)
+	if err != nil {
+		return "", 0, err
+	}
+	if !ok {
+		return ParseSocketAddr(dflt, dfltPort)
+	}
+	host, port, err = ParseSocketAddr(v, dfltPort)
+	if err != nil {
+		return "", 0, typeError(key, v, src, "a host:port address: "+err.Error())
+	}
+	return host, port, nil
+}
+
+// a fully qualified java class name, including nested classes (Outer$Inner)
+var classPattern = regexp.MustCompile(`^[\p{L}_$][\p{L}\p{N}_$]*(\.[\p{L}_$][\p{L}\p{N}_$]*)*$`)
+
+// GetClass returns the java class name key refers to, or dflt if it's not set.
+// The class is not looked up in hadoop's jars, only its name is checked.
+func (c *HadoopConf) GetClass(key string, dflt string) (string, error) {
+	v, src, ok, err := c.typedGet(key)
+	if !ok || err != nil {
+		return dflt, err
+	}
+	if !classPattern.MatchString(v) {
+		return dflt, typeError(key, v, src, "a java class name")
+	}
+	return v, nil
+}
+
+// setTyped sets key in the site file that has it, or in which its default is
+func (c *HadoopConf) setTyped(key, value string) error {
+	if _, src := c.SetIfExist(key, value); src == nil {
+		return errors.New("cannot find key " + key + " in hadoop's configuration")
+	}
+	return nil
+}
+
+func (c *HadoopConf) SetInt(key string, value int64) error {
+	return c.setTyped(key, strconv.FormatInt(value, 10))
+}
+
+func (c *HadoopConf) SetBool(key string, value bool) error {
+	return c.setTyped(key, strconv.FormatBool(value))
+}
+
+// SetBytes sets key to a size, with the largest suffix that represents it exactly, e.g. 64m
+func (c *HadoopConf) SetBytes(key string, value int64) error {
+	return c.setTyped(key, FormatBytes(value))
+}
+
+// SetDuration sets key to a duration, with the largest suffix that represents it exactly, e.g. 30s.
+// Hadoop versions which predate time suffixes cannot read such values.
+func (c *HadoopConf) SetDuration(key string, value time.Duration) error {
+	return c.setTyped(key, FormatDuration(value))
+}
+
+func (c *HadoopConf) SetStrings(key string, values ...string) error {
+	for _, v := range values {
+		if strings.Contains(v, ",") {
+			return errors.New("cannot set " + key + ", value " + strconv.Quote(v) + " contains a comma")
+		}
+	}
+	return c.setTyped(key, strings.Join(values, ","))
+}
+
+func (c *HadoopConf) SetSocketAddr(key string, host string, port int) error {
+	if host == "" || port < 0 || port > 65535 {
+		return errors.New("cannot set " + key + ", invalid address " + host + ":" + strconv.Itoa(port))
+	}
+	return c.setTyped(key, net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+func (c *HadoopConf) SetClass(key string, class string) error {
+	if !classPattern.MatchString(class) {
+		return errors.New("cannot set " + key + ", " + strconv.Quote(class) + " is not a java class name")
+	}
+	return c.setTyped(key, class)
+}
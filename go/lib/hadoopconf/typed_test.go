@@ -0,0 +1,135 @@
+package hadoopconf
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func testTypedConf(t *testing.T) *HadoopConf {
+	site, err := NewGeneratedConfFromString(Source{"core-site.xml", LocalFile}, `<configuration>
+  <property><name>int</name><value> 42 </value></property>
+  <property><name>hex</name><value>0x10</value></property>
+  <property><name>bad.int</name><value>4x2</value></property>
+  <property><name>bool</name><value>TRUE</value></property>
+  <property><name>bad.bool</name><value>yes</value></property>
+  <property><name>size</name><value>64m</value></property>
+  <property><name>size.ref</name><value>${size}</value></property>
+  <property><name>bad.size</name><value>64x</value></property>
+  <property><name>duration</name><value>10ms</value></property>
+  <property><name>duration.plain</name><value>30</value></property>
+  <property><name>bad.duration</name><value>9999999999999d</value></property>
+  <property><name>list</name><value> a, b ,,c </value></property>
+  <property><name>addr</name><value>nn.example.com:8020</value></property>
+  <property><name>addr.uri</name><value>hdfs://nn.example.com:9000/</value></property>
+  <property><name>addr.noport</name><value>nn.example.com</value></property>
+  <property><name>bad.addr</name><value>nn:port</value></property>
+  <property><name>class</name><value>org.apache.hadoop.fs.Outer$Inner</value></property>
+  <property><name>bad.class</name><value>org.apache..Foo</value></property>
+  <property><name>empty</name><value></value></property>
+</configuration>`)
+	FailOnErr(err)
+	return FromConf(&ConfWithDefault{site, nil}, &ConfWithDefault{nil, nil}, nil, nil)
+}
+
+func TestTypedGet(t *testing.T) {
+	Terst(t)
+	c := testTypedConf(t)
+	i, err := c.GetInt("int", 0)
+	Is(i, int64(42))
+	Is(err, nil)
+	i, _ = c.GetInt("hex", 0)
+	Is(i, int64(16))
+	i, _ = c.GetInt("empty", 7)
+	Is(i, int64(7))
+	i, err = c.GetInt("bad.int", 7)
+	Is(i, int64(7))
+	Is(err.Error(), `core-site.xml: bad.int="4x2" is not an integer`)
+
+	b, _ := c.GetBool("bool", false)
+	Is(b, true)
+	b, _ = c.GetBool("missing", true)
+	Is(b, true)
+	_, err = c.GetBool("bad.bool", false)
+	IsNot(err, nil)
+
+	n, _ := c.GetBytes("size", 0)
+	Is(n, int64(64<<20))
+	n, _ = c.GetBytes("size.ref", 0)
+	Is(n, int64(64<<20))
+	_, err = c.GetBytes("bad.size", 0)
+	Is(strings.HasPrefix(err.Error(), "core-site.xml: bad.size="), true)
+
+	d, _ := c.GetDuration("duration", 0, time.Second)
+	Is(d, 10*time.Millisecond)
+	d, _ = c.GetDuration("duration.plain", 0, time.Second)
+	Is(d, 30*time.Second)
+	d, _ = c.GetDuration("missing", time.Hour, time.Second)
+	Is(d, time.Hour)
+	// larger than time.Duration holds, rather than wrapping around
+	_, err = c.GetDuration("bad.duration", 0, time.Second)
+	Is(strings.HasPrefix(err.Error(), "core-site.xml: bad.duration="), true)
+	_, err = ParseDuration("9223372036854775807", time.Millisecond)
+	IsNot(err, nil)
+	d, _ = ParseDuration("106751d", time.Second)
+	Is(d, 106751*24*time.Hour)
+
+	l, _ := c.GetStrings("list")
+	Is(l, []string{"a", "b", "c"})
+	l, _ = c.GetStrings("missing", "x")
+	Is(l, []string{"x"})
+
+	host, port, _ := c.GetSocketAddr("addr", "", 0)
+	Is(host, "nn.example.com")
+	Is(port, 8020)
+	host, port, _ = c.GetSocketAddr("addr.uri", "", 0)
+	Is(host, "nn.example.com")
+	Is(port, 9000)
+	_, port, _ = c.GetSocketAddr("addr.noport", "", 50070)
+	Is(port, 50070)
+	_, _, err = c.GetSocketAddr("addr.noport", "", -1)
+	IsNot(err, nil)
+	_, _, err = c.GetSocketAddr("bad.addr", "", 0)
+	IsNot(err, nil)
+	host, port, _ = c.GetSocketAddr("missing", "0.0.0.0:50010", 0)
+	Is(host, "0.0.0.0")
+	Is(port, 50010)
+
+	class, _ := c.GetClass("class", "")
+	Is(class, "org.apache.hadoop.fs.Outer$Inner")
+	_, err = c.GetClass("bad.class", "")
+	IsNot(err, nil)
+}
+
+func TestTypedSet(t *testing.T) {
+	Terst(t)
+	c := testTypedConf(t)
+	get := func(key string) string {
+		v, _ := c.SourceGet(key)
+		return v
+	}
+	FailOnErr(c.SetBytes("size", 1<<30))
+	Is(get("size"), "1g")
+	FailOnErr(c.SetBytes("size", 1000))
+	Is(get("size"), "1000")
+	FailOnErr(c.SetDuration("duration", 90*time.Second))
+	Is(get("duration"), "90s")
+	FailOnErr(c.SetDuration("duration", 2*time.Hour))
+	Is(get("duration"), "2h")
+	FailOnErr(c.SetStrings("list", "x", "y"))
+	Is(get("list"), "x,y")
+	IsNot(c.SetStrings("list", "x,y"), nil)
+	FailOnErr(c.SetSocketAddr("addr", "::1", 8020))
+	Is(get("addr"), "[::1]:8020")
+	host, port, _ := c.GetSocketAddr("addr", "", 0)
+	Is(host, "::1")
+	Is(port, 8020)
+	IsNot(c.SetClass("class", "not a class"), nil)
+	FailOnErr(c.SetBool("bool", false))
+	Is(get("bool"), "false")
+	FailOnErr(c.SetInt("int", -3))
+	Is(get("int"), "-3")
+	IsNot(c.SetInt("no.such.key", 1), nil)
+}
@@ -0,0 +1,222 @@
+package hadoopconf
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValueType is the kind of value a property holds, as far as it can be told from its default
+type ValueType int
+
+const (
+	UnknownType ValueType = iota
+	BoolType
+	IntType
+	FloatType
+	SizeType
+	DurationType
+	SocketAddrType
+	ClassType
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case BoolType:
+		return "true or false"
+	case IntType:
+		return "an integer"
+	case FloatType:
+		return "a number"
+	case SizeType:
+		return "a size, such as 128m"
+	case DurationType:
+		return "a duration, such as 30s"
+	case SocketAddrType:
+		return "a host:port address"
+	case ClassType:
+		return "a java class name"
+	}
+	return "any value"
+}
+
+// Check returns false if value cannot be read as t. Empty values are always valid,
+// hadoop treats them as unset.
+func (t ValueType) Check(value string) bool {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return true
+	}
+	var err error
+	switch t {
+	case BoolType:
+		value = strings.ToLower(value)
+		return value == "true" || value == "false"
+	case IntType:
+		_, err = parseInt(value)
+	case FloatType:
+		_, err = strconv.ParseFloat(value, 64)
+	case SizeType:
+		_, err = ParseBytes(value)
+	case DurationType:
+		_, err = ParseDuration(value, time.Millisecond)
+	case SocketAddrType:
+		_, _, err = ParseSocketAddr(value, 0)
+	case ClassType:
+		return classPattern.MatchString(value)
+	}
+	return err == nil
+}
+
+var (
+	// descriptions of properties read with getLongBytes mention the suffixes
+	sizeDescription = regexp.MustCompile(`(?i)k\s*\(kilo\)|size prefix|\bk,\s*m,\s*g\b`)
+	// and those read with getTimeDuration the time units, such as ms(millis), s(sec)
+	durationDescription = regexp.MustCompile(`(?i)time unit|time suffix|\b(ns|us|ms)\s*(\(\w+\))?,\s*(us|ms|s)\b`)
+	// descriptions which mention suffixes we can't tell apart, the value may not be a plain number
+	suffixDescription = regexp.MustCompile(`(?i)suffix|\bunits?\b`)
+	socketAddr        = regexp.MustCompile(`^[^:/\s]+:[0-9]+$`)
+	floatValue        = regexp.MustCompile(`^-?[0-9]*\.[0-9]+$`)
+)
+
+// InferType guesses the type of a property from its default value and description.
+// Values which refer to other properties, lists and free text are UnknownType, and so are
+// numbers whose description mentions suffixes it doesn't recognize, rather than reject them.
+func InferType(value, description string) ValueType {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "" || strings.Contains(value, "${"):
+		return UnknownType
+	case strings.EqualFold(value, "true") || strings.EqualFold(value, "false"):
+		return BoolType
+	case durationDescription.MatchString(description) && DurationType.Check(value):
+		return DurationType
+	case sizeDescription.MatchString(description) && SizeType.Check(value):
+		return SizeType
+	case suffixDescription.MatchString(description):
+		return UnknownType
+	case IntType.Check(value):
+		return IntType
+	case floatValue.MatchString(value):
+		return FloatType
+	case socketAddr.MatchString(value):
+		return SocketAddrType
+	case strings.Contains(value, ".") && ClassType.Check(value):
+		// java packages are lower case and classes are capitalized, which tells
+		// a class apart from host names and dotted words
+		last := value[strings.LastIndex(value, ".")+1:]
+		if last != "" && last[:1] == strings.ToUpper(last[:1]) && strings.ToLower(value[:1]) == value[:1] {
+			return ClassType
+		}
+	}
+	return UnknownType
+}
+
+// propertyGetter is implemented by configurations which keep whole properties, with their descriptions
+type propertyGetter interface {
+	get(key string) *Property
+}
+
+// DefaultProperty returns the property which defines the default of key, in the default of any
+// of the files whose keys c looks up
+func (c *HadoopConf) DefaultProperty(key string) (p *Property, src Source) {
+	key = CurrentKey(key)
+	for _, site := range c.Sites {
+		if pg, ok := site.Default.(propertyGetter); ok && c.looksUp(site.ConfWithDefault) {
+			if p := pg.get(key); p != nil {
+				_, src := site.Default.SourceGet(key)
+				return p, src
+			}
+		}
+	}
+	return nil, NoSource
+}
+
+// KnownTypes are the types of keys hadoop reads with getTimeDuration and getLongBytes, which the
+// defaults don't always tell: older defaults describe dfs.blocksize without the size suffixes,
+// and a default of 3 may be 3 seconds
+var KnownTypes = map[string]ValueType{
+	"dfs.heartbeat.interval":                   DurationType,
+	"dfs.namenode.checkpoint.period":           DurationType,
+	"dfs.namenode.checkpoint.check.period":     DurationType,
+	"dfs.namenode.decommission.interval":       DurationType,
+	"dfs.namenode.redundancy.interval.seconds": DurationType,
+	"dfs.client.datanode-restart.timeout":      DurationType,
+	"dfs.datanode.disk.check.timeout":          DurationType,
+	"dfs.datanode.disk.check.min.gap":          DurationType,
+	"dfs.ha.tail-edits.period":                 DurationType,
+	"hadoop.service.shutdown.timeout":          DurationType,
+	"dfs.blocksize":                            SizeType,
+	"dfs.namenode.fs-limits.min-block-size":    SizeType,
+	"dfs.datanode.balance.bandwidthPerSec":     SizeType,
+	"dfs.image.transfer.bandwidthPerSec":       SizeType,
+	"dfs.datanode.max.locked.memory":           SizeType,
+	"fs.s3a.block.size":                        SizeType,
+	"fs.s3a.multipart.size":                    SizeType,
+	"fs.s3a.multipart.threshold":               SizeType,
+}
+
+// TypeOf returns the type of key in KnownTypes, or infers it from its default
+func (c *HadoopConf) TypeOf(key string) ValueType {
+	if t, ok := KnownTypes[CurrentKey(key)]; ok {
+		return t
+	}
+	if p, _ := c.DefaultProperty(key); p != nil {
+		return InferType(p.Value, p.Description)
+	}
+	return UnknownType
+}
+
+// InvalidValue is a value that does not match the type of its key
+type InvalidValue struct {
+	// where the value is set, NoSource for values not yet written
+	Source   Source
+	Key      string
+	Value    string
+	Expected ValueType
+}
+
+func (iv *InvalidValue) Error() string {
+	msg := iv.Key + "=" + strconv.Quote(iv.Value) + " is not " + iv.Expected.String()
+	if iv.Source != NoSource {
+		return iv.Source.Source + ": " + msg
+	}
+	return msg
+}
+
+// CheckValue returns an *InvalidValue error if value does not match the type of key.
+// References to other properties are expanded first.
+func (c *HadoopConf) CheckValue(key, value string) error {
+	return c.checkValue(NewExpander(c), NoSource, key, value)
+}
+
+func (c *HadoopConf) checkValue(expander *Expander, src Source, key, value string) error {
+	t := c.TypeOf(key)
+	if t == UnknownType {
+		return nil
+	}
+	expanded, err := expander.Expand(value)
+	if err != nil {
+		return err
+	}
+	if !t.Check(expanded) {
+		return &InvalidValue{src, key, value, t}
+	}
+	return nil
+}
+
+// Validate checks the values of all keys in the site files against the types of their defaults
+func (c *HadoopConf) Validate() []*InvalidValue {
+	invalid := []*InvalidValue{}
+	expander := NewExpander(c)
+	for _, site := range c.HadoopSites() {
+		for _, key := range site.Conf.Keys() {
+			v, src := site.Conf.SourceGet(key)
+			if err, ok := c.checkValue(expander, src, key, v).(*InvalidValue); ok {
+				invalid = append(invalid, err)
+			}
+		}
+	}
+	return invalid
+}
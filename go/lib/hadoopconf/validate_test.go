@@ -0,0 +1,108 @@
+package hadoopconf
+
+import (
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestInferType(t *testing.T) {
+	Terst(t)
+	Is(InferType("3", "Default block replication."), IntType)
+	Is(InferType("0x10", ""), IntType)
+	Is(InferType(" true ", ""), BoolType)
+	Is(InferType("0.75", ""), FloatType)
+	Is(InferType("134217728", "You can use the following suffix (case insensitive): k(kilo), m(mega), g(giga)"), SizeType)
+	Is(InferType("128m", "You can use the following suffix (case insensitive): k(kilo), m(mega), g(giga)"), SizeType)
+	Is(InferType("30s", "Support multiple time unit suffix(case insensitive)"), DurationType)
+	Is(InferType("0.0.0.0:50070", ""), SocketAddrType)
+	Is(InferType("org.apache.hadoop.fs.LocalFileSystem", ""), ClassType)
+	Is(InferType("dfs.example.com", ""), UnknownType)
+	Is(InferType("file://${hadoop.tmp.dir}/dfs/name", ""), UnknownType)
+	Is(InferType("", ""), UnknownType)
+	Is(InferType("simple", ""), UnknownType)
+	// a number, of units we can't tell
+	Is(InferType("5", "Timeout, the value may have a unit suffix"), UnknownType)
+}
+
+// descriptions from hadoop 3's hdfs-default.xml
+const (
+	heartbeatIntervalDescription = `Determines datanode heartbeat interval in seconds.
+    Can use the following suffix (case insensitive):
+    ms(millis), s(sec), m(min), h(hour), d(day)
+    to specify the time (such as 2s, 2m, 1h, etc.).
+    Or provide complete number in seconds (such as 30 for 30 seconds).`
+	checkpointPeriodDescription = `The number of seconds between two periodic checkpoints.
+    Support multiple time unit suffix(case insensitive), as described
+    in dfs.heartbeat.interval.If no time unit is specified then seconds
+    is assumed.`
+	blocksizeDescription = `The default block size for new files, in bytes.
+      You can use the following suffix (case insensitive):
+      k(kilo), m(mega), g(giga), t(tera), p(peta), e(exa) to specify the size (such as 128k, 512m, 1g, etc.),
+      Or provide complete size in bytes (such as 134217728 for 128 MB).`
+	replicationDescription = `Default block replication.
+  The actual number of replications can be specified when the file is created.
+  The default is used if replication is not specified in create time.`
+	handlerCountDescription = `The number of Namenode RPC server threads that listen to
+  requests from clients.
+  If dfs.namenode.servicerpc-address is not configured then
+  Namenode RPC server threads listen to requests from all nodes.`
+)
+
+func TestInferTypeHdfsDefault(t *testing.T) {
+	Terst(t)
+	Is(InferType("3", heartbeatIntervalDescription), DurationType)
+	Is(InferType("3600s", checkpointPeriodDescription), DurationType)
+	Is(InferType("134217728", blocksizeDescription), SizeType)
+	Is(InferType("3", replicationDescription), IntType)
+	Is(InferType("10", handlerCountDescription), IntType)
+
+	dflt, err := NewGeneratedConfFromString(Source{"hdfs-default.xml", FileFromJar}, `<configuration>
+  <property><name>dfs.heartbeat.interval</name><value>3</value><description>`+heartbeatIntervalDescription+`</description></property>
+  <property><name>dfs.replication</name><value>3</value><description>`+replicationDescription+`</description></property>
+  <property><name>dfs.blocksize</name><value>67108864</value><description>The default block size for new files.</description></property>
+</configuration>`)
+	FailOnErr(err)
+	c := FromConf(&ConfWithDefault{nil, nil}, &ConfWithDefault{nil, dflt}, nil, nil)
+	Is(c.CheckValue("dfs.heartbeat.interval", "10s"), nil)
+	Is(c.CheckValue("dfs.heartbeat.interval", "3"), nil)
+	IsNot(c.CheckValue("dfs.heartbeat.interval", "soon"), nil)
+	IsNot(c.CheckValue("dfs.replication", "3s"), nil)
+	// older defaults don't mention the suffixes, hadoop reads dfs.blocksize with them anyway
+	Is(c.TypeOf("dfs.blocksize"), SizeType)
+	Is(c.CheckValue("dfs.blocksize", "64m"), nil)
+}
+
+func TestValidate(t *testing.T) {
+	Terst(t)
+	site, err := NewGeneratedConfFromString(Source{"hdfs-site.xml", LocalFile}, `<configuration>
+  <property><name>dfs.replication</name><value>three</value></property>
+  <property><name>dfs.blocksize</name><value>${block}</value></property>
+  <property><name>block</name><value>256m</value></property>
+  <property><name>dfs.http.address</name><value>nn:port</value></property>
+  <property><name>dfs.permissions.enabled</name><value>TRUE</value></property>
+</configuration>`)
+	FailOnErr(err)
+	dflt, err := NewGeneratedConfFromString(Source{"hdfs-default.xml", FileFromJar}, `<configuration>
+  <property><name>dfs.replication</name><value>3</value></property>
+  <property><name>dfs.blocksize</name><value>134217728</value><description>You can use the following suffix (case insensitive): k(kilo), m(mega)</description></property>
+  <property><name>dfs.namenode.http-address</name><value>0.0.0.0:50070</value></property>
+  <property><name>dfs.permissions.enabled</name><value>true</value></property>
+</configuration>`)
+	FailOnErr(err)
+	c := FromConf(&ConfWithDefault{nil, nil}, &ConfWithDefault{site, dflt}, nil, nil)
+	Is(c.TypeOf("dfs.http.address"), SocketAddrType)
+	invalid := c.Validate()
+	if Is(len(invalid), 2) {
+		Is(invalid[0].Error(), `hdfs-site.xml: dfs.replication="three" is not an integer`)
+		Is(invalid[1].Key, "dfs.http.address")
+		Is(invalid[1].Expected, SocketAddrType)
+	}
+	Is(c.CheckValue("dfs.replication", "2"), nil)
+	Is(c.CheckValue("dfs.replication", "${no.such.key}").Error(), `dfs.replication="${no.such.key}" is not an integer`)
+	Is(c.CheckValue("dfs.blocksize", "8G"), nil)
+	IsNot(c.CheckValue("dfs.blocksize", "8 gigs"), nil)
+	Is(c.CheckValue("dfs.heartbeat.interval", "30s"), nil)
+	IsNot(c.CheckValue("dfs.heartbeat.interval", "9999999999999d"), nil)
+	Is(c.CheckValue("unknown.key", "anything"), nil)
+}
@@ -0,0 +1,111 @@
+package hadoopconf
+
+import (
+	"encoding/xml"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const xincludeNamespace = "http://www.w3.org/2001/XInclude"
+
+// XInclude is an <xi:include href="..."/> element of a configuration file.
+// Hadoop reads the properties of the included file as if they were written
+// in place of the element.
+type XInclude struct {
+	Href string
+	// properties in <xi:fallback>, used when the included file does not exist
+	Fallback    []*Property
+	HasFallback bool
+	// offset of the element in the including file
+	at int
+}
+
+func isXInclude(name xml.Name, local string) bool {
+	return name.Local == local && (name.Space == xincludeNamespace || name.Space == "xi")
+}
+
+// parseXInclude reads an include element, after its start element was read from d
+func parseXInclude(d *xml.Decoder, start xml.StartElement, src []byte) (*XInclude, error) {
+	inc := &XInclude{}
+	for _, attr := range start.Attr {
+		if attr.Name.Local == "href" {
+			inc.Href = attr.Value
+		}
+	}
+	depth := 0
+	for {
+		off := int(d.InputOffset())
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1 && isXInclude(t.Name, "fallback"):
+				inc.HasFallback = true
+			case depth == 2 && inc.HasFallback && t.Name.Local == "property":
+				p, err := parseProperty(d, t, src, off)
+				if err != nil {
+					return nil, err
+				}
+				depth--
+				inc.Fallback = append(inc.Fallback, p)
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				return inc, nil
+			}
+			depth--
+		}
+	}
+}
+
+// path returns the file the include refers to, relative hrefs are relative to the including file
+func (inc *XInclude) path(including string) string {
+	p := strings.TrimPrefix(inc.Href, "file://")
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(filepath.Dir(including), p)
+	}
+	return p
+}
+
+// resolveIncludes reads the files fc includes. If an included file is missing,
+// the properties of its fallback become part of fc.
+// including is the chain of files that lead to fc, used to detect include cycles.
+func (fc *FileConfiguration) resolveIncludes(including []string) error {
+	for _, inc := range fc.XIncludes {
+		path := inc.path(fc.Path)
+		for _, p := range including {
+			if p == path {
+				return errors.New("include cycle: " + strings.Join(append(including, path), " -> "))
+			}
+		}
+		if _, err := os.Stat(path); err == nil {
+			included, err := newFileConfiguration(path, including)
+			if err != nil {
+				return err
+			}
+			included.at = inc.at
+			fc.Includes = append(fc.Includes, included)
+		} else if inc.HasFallback {
+			fc.Property = append(fc.Property, inc.Fallback...)
+		} else {
+			return errors.New("cannot include " + path + " from " + fc.Path + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// Fragments returns all files fc includes, directly or indirectly
+func (fc *FileConfiguration) Fragments() []*FileConfiguration {
+	fragments := []*FileConfiguration{}
+	for _, inc := range fc.Includes {
+		fragments = append(fragments, inc)
+		fragments = append(fragments, inc.Fragments()...)
+	}
+	return fragments
+}
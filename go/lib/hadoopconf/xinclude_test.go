@@ -0,0 +1,141 @@
+package hadoopconf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "gohadoopconf-xinclude")
+	FailOnErr(err)
+	for name, content := range files {
+		FailOnErr(os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		FailOnErr(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func readFile(path string) string {
+	b, err := ioutil.ReadFile(path)
+	FailOnErr(err)
+	return string(b)
+}
+
+func TestXInclude(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"hdfs-site.xml": `<configuration xmlns:xi="http://www.w3.org/2001/XInclude">
+  <property><name>dfs.replication</name><value>2</value></property>
+  <xi:include href="fragments/nn.xml"/>
+  <xi:include href="missing.xml">
+    <xi:fallback>
+      <property><name>dfs.fallback</name><value>fallback</value></property>
+    </xi:fallback>
+  </xi:include>
+</configuration>
+`,
+		"fragments/nn.xml": `<configuration xmlns:xi="http://www.w3.org/2001/XInclude">
+  <property><name>dfs.namenode.name.dir</name><value>/data/nn</value></property>
+  <xi:include href="../dn.xml"/>
+</configuration>
+`,
+		"dn.xml": `<configuration>
+  <property><name>dfs.datanode.data.dir</name><value>/data/dn</value></property>
+</configuration>
+`,
+	})
+	defer os.RemoveAll(dir)
+	fc, err := NewFileConfiguration(filepath.Join(dir, "hdfs-site.xml"))
+	FailOnErr(err)
+	Is(len(fc.Fragments()), 2)
+	Is(strings.Join(fc.Keys(), " "), "dfs.replication dfs.fallback dfs.namenode.name.dir dfs.datanode.data.dir")
+	ValSrc(fc.SourceGet("dfs.replication")).Is("2", "hdfs-site.xml")
+	ValSrc(fc.SourceGet("dfs.fallback")).Is("fallback", "hdfs-site.xml")
+	ValSrc(fc.SourceGet("dfs.namenode.name.dir")).Is("/data/nn", "nn.xml")
+	ValSrc(fc.SourceGet("dfs.datanode.data.dir")).Is("/data/dn", "dn.xml")
+
+	Is(fc.Set("dfs.datanode.data.dir", "/data1/dn"), "/data/dn")
+	Is(fc.Set("dfs.fallback", "changed"), "fallback")
+	fc.Set("dfs.new", "new")
+	FailOnErr(fc.Save(false))
+	Is(readFile(filepath.Join(dir, "dn.xml")), `<configuration>
+  <property><name>dfs.datanode.data.dir</name><value>/data1/dn</value></property>
+</configuration>
+`)
+	Is(strings.Contains(readFile(filepath.Join(dir, "hdfs-site.xml")), "<value>changed</value>"), true)
+	Is(strings.Contains(readFile(filepath.Join(dir, "hdfs-site.xml")), "<name>dfs.new</name>"), true)
+	Is(strings.Contains(readFile(filepath.Join(dir, "fragments", "nn.xml")), "dfs.new"), false)
+
+	fc, err = NewFileConfiguration(filepath.Join(dir, "hdfs-site.xml"))
+	FailOnErr(err)
+	ValSrc(fc.SourceGet("dfs.datanode.data.dir")).Is("/data1/dn", "dn.xml")
+	Is(fc.Delete("dfs.datanode.data.dir"), "/data1/dn")
+	ValSrc(fc.SourceGet("dfs.datanode.data.dir")).Empty()
+}
+
+func TestXIncludeErrors(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"missing.xml": `<configuration xmlns:xi="http://www.w3.org/2001/XInclude">
+  <xi:include href="nothere.xml"/>
+</configuration>`,
+		"a.xml": `<configuration xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="b.xml"/></configuration>`,
+		"b.xml": `<configuration xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="a.xml"/></configuration>`,
+	})
+	defer os.RemoveAll(dir)
+	_, err := NewFileConfiguration(filepath.Join(dir, "missing.xml"))
+	IsNot(err, nil)
+	_, err = NewFileConfiguration(filepath.Join(dir, "a.xml"))
+	if IsNot(err, nil) {
+		Is(strings.HasPrefix(err.Error(), "include cycle"), true)
+	}
+}
+
+func TestXIncludeOrder(t *testing.T) {
+	Terst(t)
+	dir := writeFiles(t, map[string]string{
+		"hdfs-site.xml": `<configuration xmlns:xi="http://www.w3.org/2001/XInclude">
+  <property><name>dfs.replication</name><value>2</value></property>
+  <property><name>dfs.blocksize</name><value>64m</value><final>true</final></property>
+  <property><name>dfs.permissions.enabled</name><value>true</value></property>
+  <property><name>dfs.permissions.enabled</name><value>false</value></property>
+  <xi:include href="after.xml"/>
+  <property><name>dfs.heartbeat.interval</name><value>3</value></property>
+</configuration>
+`,
+		"after.xml": `<configuration>
+  <property><name>dfs.replication</name><value>3</value></property>
+  <property><name>dfs.blocksize</name><value>128m</value></property>
+  <property><name>dfs.heartbeat.interval</name><value>5</value></property>
+</configuration>
+`,
+	})
+	defer os.RemoveAll(dir)
+	fc, err := NewFileConfiguration(filepath.Join(dir, "hdfs-site.xml"))
+	FailOnErr(err)
+	// the last definition wins, the include is read where it is
+	ValSrc(fc.SourceGet("dfs.replication")).Is("3", "after.xml")
+	ValSrc(fc.SourceGet("dfs.heartbeat.interval")).Is("3", "hdfs-site.xml")
+	ValSrc(fc.SourceGet("dfs.permissions.enabled")).Is("false", "hdfs-site.xml")
+	// unless an earlier one is final
+	ValSrc(fc.SourceGet("dfs.blocksize")).Is("64m", "hdfs-site.xml")
+	src, final := fc.FinalSource("dfs.blocksize")
+	Is(final, true)
+	Is(filepath.Base(src.Source), "hdfs-site.xml")
+
+	// set changes the definition hadoop uses
+	Is(fc.Set("dfs.replication", "4"), "3")
+	Is(fc.Set("dfs.permissions.enabled", "true"), "false")
+	FailOnErr(fc.Save(false))
+	Is(strings.Contains(readFile(filepath.Join(dir, "after.xml")), "<value>4</value>"), true)
+	Is(strings.Contains(readFile(filepath.Join(dir, "hdfs-site.xml")), "<value>2</value>"), true)
+	fc, err = NewFileConfiguration(filepath.Join(dir, "hdfs-site.xml"))
+	FailOnErr(err)
+	ValSrc(fc.SourceGet("dfs.replication")).Is("4", "after.xml")
+	ValSrc(fc.SourceGet("dfs.permissions.enabled")).Is("true", "hdfs-site.xml")
+}
@@ -0,0 +1,291 @@
+package hadoopconf
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// span is a [start, end) byte range in the original document
+type span struct {
+	start, end int
+}
+
+// rawProperty remembers where a property was found in the original document,
+// and how it looked like, so that we can write back unmodified properties verbatim,
+// and touch only the <value> of modified ones.
+type rawProperty struct {
+	block span // <property>...</property>
+	value span // text inside <value>...</value>, start < 0 if there's no such text
+	orig  Property
+	// the property was removed from the configuration
+	deleted bool
+}
+
+// xmlDoc is the original text of a parsed configuration file, the configuration
+// is rendered by editing it, so that comments, processing instructions and
+// formatting are kept as is.
+type xmlDoc struct {
+	src []byte
+	// all properties in the order they appeared in the document
+	props []*Property
+	// offset of </configuration>, negative if there isn't any
+	end int
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func parseConfiguration(b []byte) (*Configuration, error) {
+	c := &Configuration{doc: &xmlDoc{src: b, end: -1}}
+	d := xml.NewDecoder(bytes.NewReader(b))
+	depth := 0
+	seenRoot := false
+	for {
+		off := int(d.InputOffset())
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 1:
+				if t.Name.Local != "configuration" {
+					return nil, errors.New("expected element type <configuration> but have <" + t.Name.Local + ">")
+				}
+				c.XMLName = t.Name
+				seenRoot = true
+			case depth == 2 && t.Name.Local == "property":
+				p, err := parseProperty(d, t, b, off)
+				if err != nil {
+					return nil, err
+				}
+				depth--
+				c.Property = append(c.Property, p)
+				c.doc.props = append(c.doc.props, p)
+			case depth == 2 && isXInclude(t.Name, "include"):
+				inc, err := parseXInclude(d, t, b)
+				if err != nil {
+					return nil, err
+				}
+				depth--
+				inc.at = off
+				c.XIncludes = append(c.XIncludes, inc)
+				c.doc.props = append(c.doc.props, inc.Fallback...)
+			}
+		case xml.EndElement:
+			// <configuration/> has no closing tag to add properties before
+			if depth == 1 && !bytes.HasSuffix(b[:d.InputOffset()], []byte("/>")) {
+				c.doc.end = off
+			}
+			depth--
+		}
+	}
+	if !seenRoot {
+		return nil, io.EOF
+	}
+	return c, nil
+}
+
+// parseProperty reads a property element, after its start element was read from d
+func parseProperty(d *xml.Decoder, start xml.StartElement, src []byte, off int) (*Property, error) {
+	p := &Property{}
+	for _, attr := range start.Attr {
+		setPropertyField(p, attr.Name.Local, attr.Value)
+	}
+	r := &rawProperty{block: span{off, -1}, value: span{-1, -1}}
+	field := ""
+	text := []byte{}
+	depth := 0
+	for {
+		off := int(d.InputOffset())
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			if depth == 1 {
+				field = t.Name.Local
+				text = text[:0]
+				if field == "value" {
+					r.value.start = int(d.InputOffset())
+				}
+			}
+		case xml.CharData:
+			if depth == 1 {
+				text = append(text, t...)
+			}
+		case xml.EndElement:
+			if depth == 0 {
+				r.block.end = int(d.InputOffset())
+				// <value/> has no text we can replace
+				if r.value.start >= 2 && r.value.start == r.value.end && string(src[r.value.start-2:r.value.start]) == "/>" {
+					r.value = span{-1, -1}
+				}
+				r.orig = *p
+				p.raw = r
+				return p, nil
+			}
+			if depth == 1 {
+				setPropertyField(p, field, string(text))
+				if field == "value" {
+					r.value.end = off
+				}
+			}
+			depth--
+		}
+	}
+}
+
+func setPropertyField(p *Property, field, value string) {
+	switch field {
+	case "name":
+		p.Name = value
+	case "value":
+		p.Value = value
+	case "description":
+		p.Description = value
+	case "final":
+		p.Final, _ = strconv.ParseBool(strings.TrimSpace(value))
+	}
+}
+
+// lineStart returns the beginning of the line pos is at, and whether
+// there are only spaces between them
+func (doc *xmlDoc) lineStart(pos int) (int, bool) {
+	i := pos
+	for i > 0 && (doc.src[i-1] == ' ' || doc.src[i-1] == '\t') {
+		i--
+	}
+	if i == 0 || doc.src[i-1] == '\n' {
+		return i, true
+	}
+	return pos, false
+}
+
+// lineSpan extends s to whole lines, if nothing else is written on them
+func (doc *xmlDoc) lineSpan(s span) (start, end int) {
+	start, ownLine := doc.lineStart(s.start)
+	end = s.end
+	for end < len(doc.src) && (doc.src[end] == ' ' || doc.src[end] == '\t' || doc.src[end] == '\r') {
+		end++
+	}
+	if !ownLine || (end < len(doc.src) && doc.src[end] != '\n') {
+		return s.start, s.end
+	}
+	if end < len(doc.src) {
+		end++
+	}
+	return start, end
+}
+
+func (doc *xmlDoc) newline() string {
+	if bytes.Contains(doc.src, []byte("\r\n")) {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// indentation returns the indentation of <property>, and of its children, in the
+// original document, so that new properties look like the old ones.
+func (doc *xmlDoc) indentation() (indent, childIndent string, oneLine bool) {
+	if len(doc.props) == 0 {
+		return "  ", "    ", false
+	}
+	block := doc.props[0].raw.block
+	if start, ok := doc.lineStart(block.start); ok {
+		indent = string(doc.src[start:block.start])
+	}
+	text := doc.src[block.start:block.end]
+	nl := bytes.IndexByte(text, '\n')
+	if nl < 0 {
+		return indent, "", true
+	}
+	rest := text[nl+1:]
+	return indent, string(rest[:len(rest)-len(bytes.TrimLeft(rest, " \t"))]), false
+}
+
+func (doc *xmlDoc) renderProperty(p *Property) string {
+	indent, childIndent, oneLine := doc.indentation()
+	sep := doc.newline() + childIndent
+	if oneLine {
+		sep = ""
+	}
+	b := new(bytes.Buffer)
+	b.WriteString("<property>")
+	b.WriteString(sep + "<name>" + xmlEscaper.Replace(p.Name) + "</name>")
+	b.WriteString(sep + "<value>" + xmlEscaper.Replace(p.Value) + "</value>")
+	if p.Description != "" {
+		b.WriteString(sep + "<description>" + xmlEscaper.Replace(p.Description) + "</description>")
+	}
+	if p.Final {
+		b.WriteString(sep + "<final>true</final>")
+	}
+	if !oneLine {
+		b.WriteString(doc.newline() + indent)
+	}
+	b.WriteString("</property>")
+	return b.String()
+}
+
+// render writes c into the original document, modifying only what changed.
+// It returns nil if c cannot be written into the original document.
+func (doc *xmlDoc) render(c *Configuration) []byte {
+	b := new(bytes.Buffer)
+	last := 0
+	for _, p := range doc.props {
+		r := p.raw
+		switch {
+		case r.deleted:
+			start, end := doc.lineSpan(r.block)
+			b.Write(doc.src[last:start])
+			last = end
+		case p.Name == r.orig.Name && p.Value == r.orig.Value && p.Description == r.orig.Description && p.Final == r.orig.Final:
+			continue
+		case r.value.start >= 0 && p.Name == r.orig.Name && p.Description == r.orig.Description && p.Final == r.orig.Final:
+			b.Write(doc.src[last:r.value.start])
+			b.WriteString(xmlEscaper.Replace(p.Value))
+			last = r.value.end
+		default:
+			b.Write(doc.src[last:r.block.start])
+			b.WriteString(doc.renderProperty(p))
+			last = r.block.end
+		}
+	}
+	added := []*Property{}
+	for _, p := range c.Property {
+		if p.raw == nil {
+			added = append(added, p)
+		}
+	}
+	if len(added) > 0 && doc.end < 0 {
+		return nil
+	}
+	if len(added) > 0 {
+		indent, _, _ := doc.indentation()
+		at, ownLine := doc.lineStart(doc.end)
+		b.Write(doc.src[last:at])
+		if ownLine {
+			for _, p := range added {
+				b.WriteString(indent + doc.renderProperty(p) + doc.newline())
+			}
+		} else {
+			for _, p := range added {
+				b.WriteString(doc.newline() + indent + doc.renderProperty(p))
+			}
+			b.WriteString(doc.newline())
+		}
+		last = at
+	}
+	b.Write(doc.src[last:])
+	return b.Bytes()
+}
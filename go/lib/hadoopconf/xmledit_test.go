@@ -0,0 +1,95 @@
+package hadoopconf
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/robertkrimen/terst"
+)
+
+func TestLosslessRoundTrip(t *testing.T) {
+	Terst(t)
+	conf, err := NewConfigurationFromString(coreSite)
+	Is(err, nil)
+	Is(conf.String(), coreSite)
+	conf, err = NewConfigurationFromString(coreDefault)
+	Is(err, nil)
+	Is(conf.String(), coreDefault)
+}
+
+func TestLosslessSet(t *testing.T) {
+	Terst(t)
+	conf, err := NewConfigurationFromString(coreSite)
+	Is(err, nil)
+	Is(conf.Set("hadoop.security.authorization", "false & <true>"), "true")
+	Is(conf.String(), strings.Replace(coreSite,
+		"<value>true</value>", "<value>false &amp; &lt;true&gt;</value>", 1))
+	newconf, err := NewConfigurationFromString(conf.String())
+	Is(err, nil)
+	Is(newconf.Get("hadoop.security.authorization"), "false & <true>")
+
+	conf.Set("new.property", "new")
+	Is(conf.String(), strings.Replace(strings.Replace(coreSite,
+		"<value>true</value>", "<value>false &amp; &lt;true&gt;</value>", 1),
+		"</configuration>", `     <property>
+         <name>new.property</name>
+         <value>new</value>
+     </property>
+</configuration>`, 1))
+}
+
+func TestLosslessNewProperties(t *testing.T) {
+	Terst(t)
+	conf, err := NewConfigurationFromString(`<?xml version="1.0"?>
+<!-- empty -->
+<configuration>
+</configuration>
+`)
+	Is(err, nil)
+	conf.Set("a", "b")
+	Is(conf.String(), `<?xml version="1.0"?>
+<!-- empty -->
+<configuration>
+  <property>
+    <name>a</name>
+    <value>b</value>
+  </property>
+</configuration>
+`)
+	conf, err = NewConfigurationFromString(`<configuration><property><name>a</name><value/></property></configuration>`)
+	Is(err, nil)
+	conf.Set("a", "b")
+	conf.Set("c", "d")
+	Is(conf.String(), `<configuration><property><name>a</name><value>b</value></property>
+<property><name>c</name><value>d</value></property>
+</configuration>`)
+	conf, err = NewConfigurationFromString(`<configuration/>`)
+	Is(err, nil)
+	conf.Set("a", "b")
+	newconf, err := NewConfigurationFromString(conf.String())
+	Is(err, nil)
+	Is(newconf.Get("a"), "b")
+}
+
+func TestLosslessDelete(t *testing.T) {
+	Terst(t)
+	conf, err := NewConfigurationFromString(coreSite)
+	Is(err, nil)
+	Is(conf.Delete("fs.default.name"), "hdfs://localhost:8020")
+	Is(conf.Get("fs.default.name"), "")
+	Is(conf.String(), strings.Replace(coreSite, `     <property>
+         <name>fs.default.name</name>
+         <value>hdfs://localhost:8020</value>
+     </property>
+`, "", 1))
+	Is(conf.Delete("no.such.key"), "")
+
+	conf, err = NewConfigurationFromString(`<configuration><property><name>a</name><value>b</value></property><property><name>c</name><value>d</value></property></configuration>`)
+	Is(err, nil)
+	conf.Delete("a")
+	Is(conf.String(), `<configuration><property><name>c</name><value>d</value></property></configuration>`)
+	conf.Set("a", "e")
+	Is(conf.String(), `<configuration><property><name>c</name><value>d</value></property>
+<property><name>a</name><value>e</value></property>
+</configuration>`)
+}